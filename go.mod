@@ -0,0 +1,16 @@
+module rv
+
+go 1.26.0
+
+require (
+	github.com/andybalholm/cascadia v1.3.3
+	github.com/gomodule/redigo v1.9.3
+	go.etcd.io/bbolt v1.4.3
+	golang.org/x/net v0.60.0
+	golang.org/x/text v0.42.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require golang.org/x/sys v0.48.0
+
+replace golang.org/x/sys => golang.org/x/sys v0.47.0
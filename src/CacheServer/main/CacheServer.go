@@ -0,0 +1,463 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	cache "rv/LRUCache"
+)
+
+// 基于LRUCache的缓存服务，使用Redis的RESP协议通信，redigo等Redis客户端可以直接连接。
+// 支持的命令：GET、SET（EX/PX）、DEL、EXISTS、HGET、HSET、HEXISTS、TTL、DBSIZE、FLUSHALL，
+// 以及客户端连接时常用的PING、ECHO、AUTH、SELECT、QUIT。
+
+var (
+	addr     = flag.String("addr", ":6380", "监听地址")
+	capacity = flag.Int("capacity", 100000, "缓存最多能放的key个数")
+	password = flag.String("requirepass", "", "客户端需要通过AUTH提供的密码，为空表示不需要密码")
+)
+
+func main() {
+	flag.Parse()
+
+	listener, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Println("cache server listening on", listener.Addr())
+
+	s := newServer(*capacity, *password)
+	log.Fatal(s.serve(listener))
+}
+
+type server struct {
+	cache    *cache.Cache
+	password string
+	hashLock sync.Mutex // HSET需要先取出hash再修改，串行执行避免并发创建同一个hash
+}
+
+// 哈希类型的值，对应Redis的hash
+type hash struct {
+	lock   sync.RWMutex
+	fields map[string]string
+}
+
+var (
+	errWrongType   = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+	errSyntax      = errors.New("ERR syntax error")
+	errNotInteger  = errors.New("ERR value is not an integer or out of range")
+	errNoAuth      = errors.New("NOAUTH Authentication required.")
+	errInvalidPass = errors.New("WRONGPASS invalid username-password pair or user is disabled.")
+)
+
+func newServer(capacity int, password string) *server {
+	return &server{cache: cache.NewCache(capacity), password: password}
+}
+
+// 接受连接，每个连接一个goroutine，直到listener被关闭
+func (s *server) serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go s.handleConn(conn)
+	}
+}
+
+// 一个客户端连接的状态
+type client struct {
+	reader        *bufio.Reader
+	writer        *bufio.Writer
+	authenticated bool
+	quit          bool
+}
+
+func (s *server) handleConn(conn net.Conn) {
+	defer conn.Close()
+	c := &client{
+		reader:        bufio.NewReader(conn),
+		writer:        bufio.NewWriter(conn),
+		authenticated: s.password == "",
+	}
+	for !c.quit {
+		args, err := readCommand(c.reader, c.authenticated)
+		if err != nil {
+			if err != io.EOF {
+				writeError(c.writer, fmt.Errorf("ERR Protocol error: %v", err))
+				_ = c.writer.Flush()
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		s.execute(c, args)
+		// 客户端使用pipeline时，等缓冲区中的命令都处理完再一起发送
+		if c.reader.Buffered() == 0 || c.quit {
+			if err := c.writer.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// 命令处理函数，args[0]是命令名
+type command struct {
+	arity   int // 参数个数（包括命令名），负数表示至少-arity个
+	handler func(s *server, c *client, args []string)
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"PING":     {-1, (*server).ping},
+		"ECHO":     {2, (*server).echo},
+		"AUTH":     {-2, (*server).auth},
+		"SELECT":   {2, (*server).selectDB},
+		"QUIT":     {1, (*server).quit},
+		"GET":      {2, (*server).get},
+		"SET":      {-3, (*server).set},
+		"DEL":      {-2, (*server).del},
+		"EXISTS":   {-2, (*server).exists},
+		"HGET":     {3, (*server).hget},
+		"HSET":     {-4, (*server).hset},
+		"HEXISTS":  {3, (*server).hexists},
+		"TTL":      {2, (*server).ttl},
+		"DBSIZE":   {1, (*server).dbsize},
+		"FLUSHALL": {-1, (*server).flushall},
+	}
+}
+
+func (s *server) execute(c *client, args []string) {
+	name := strings.ToUpper(args[0])
+	cmd, exist := commands[name]
+	if !exist {
+		writeError(c.writer, fmt.Errorf("ERR unknown command '%s'", args[0]))
+		return
+	}
+	if (cmd.arity > 0 && len(args) != cmd.arity) || (cmd.arity < 0 && len(args) < -cmd.arity) {
+		writeError(c.writer, fmt.Errorf("ERR wrong number of arguments for '%s' command", strings.ToLower(name)))
+		return
+	}
+	if !c.authenticated && name != "AUTH" && name != "QUIT" {
+		writeError(c.writer, errNoAuth)
+		return
+	}
+	cmd.handler(s, c, args)
+}
+
+func (s *server) ping(c *client, args []string) {
+	if len(args) > 1 {
+		writeBulk(c.writer, args[1])
+		return
+	}
+	writeSimple(c.writer, "PONG")
+}
+
+func (s *server) echo(c *client, args []string) {
+	writeBulk(c.writer, args[1])
+}
+
+// 没有设置密码时任何AUTH都会成功，这样配置了密码的客户端也能直接连接
+func (s *server) auth(c *client, args []string) {
+	pass := args[len(args)-1]
+	if s.password != "" && pass != s.password {
+		writeError(c.writer, errInvalidPass)
+		return
+	}
+	c.authenticated = true
+	writeSimple(c.writer, "OK")
+}
+
+// 只有一个数据库，SELECT任何库都返回OK
+func (s *server) selectDB(c *client, args []string) {
+	if _, err := strconv.Atoi(args[1]); err != nil {
+		writeError(c.writer, errNotInteger)
+		return
+	}
+	writeSimple(c.writer, "OK")
+}
+
+func (s *server) quit(c *client, args []string) {
+	c.quit = true
+	writeSimple(c.writer, "OK")
+}
+
+func (s *server) get(c *client, args []string) {
+	val, ok := s.cache.GetOK(args[1])
+	if !ok {
+		writeNil(c.writer)
+		return
+	}
+	str, isString := val.(string)
+	if !isString {
+		writeError(c.writer, errWrongType)
+		return
+	}
+	writeBulk(c.writer, str)
+}
+
+// SET key value [EX seconds|PX milliseconds]
+func (s *server) set(c *client, args []string) {
+	var ttl time.Duration
+	for i := 3; i < len(args); i++ {
+		opt := strings.ToUpper(args[i])
+		if (opt != "EX" && opt != "PX") || i+1 >= len(args) || ttl != 0 {
+			writeError(c.writer, errSyntax)
+			return
+		}
+		n, err := strconv.ParseInt(args[i+1], 10, 64)
+		if err != nil || n <= 0 {
+			writeError(c.writer, fmt.Errorf("ERR invalid expire time in 'set' command"))
+			return
+		}
+		if opt == "EX" {
+			ttl = time.Duration(n) * time.Second
+		} else {
+			ttl = time.Duration(n) * time.Millisecond
+		}
+		i++
+	}
+	s.cache.PutWithTTL(args[1], args[2], ttl)
+	writeSimple(c.writer, "OK")
+}
+
+func (s *server) del(c *client, args []string) {
+	deleted := 0
+	for _, key := range args[1:] {
+		if s.cache.Delete(key) {
+			deleted++
+		}
+	}
+	writeInt(c.writer, int64(deleted))
+}
+
+func (s *server) exists(c *client, args []string) {
+	count := 0
+	for _, key := range args[1:] {
+		if s.cache.Contains(key) {
+			count++
+		}
+	}
+	writeInt(c.writer, int64(count))
+}
+
+// 取出key对应的hash，key不存在时返回nil，类型不对时返回errWrongType
+func (s *server) getHash(key string) (*hash, error) {
+	val, ok := s.cache.GetOK(key)
+	if !ok {
+		return nil, nil
+	}
+	h, isHash := val.(*hash)
+	if !isHash {
+		return nil, errWrongType
+	}
+	return h, nil
+}
+
+func (s *server) hget(c *client, args []string) {
+	h, err := s.getHash(args[1])
+	if err != nil {
+		writeError(c.writer, err)
+		return
+	}
+	if h == nil {
+		writeNil(c.writer)
+		return
+	}
+	h.lock.RLock()
+	val, exist := h.fields[args[2]]
+	h.lock.RUnlock()
+	if !exist {
+		writeNil(c.writer)
+		return
+	}
+	writeBulk(c.writer, val)
+}
+
+// HSET key field value [field value ...]，返回新增的field个数
+func (s *server) hset(c *client, args []string) {
+	if len(args)%2 != 0 {
+		writeError(c.writer, fmt.Errorf("ERR wrong number of arguments for 'hset' command"))
+		return
+	}
+	s.hashLock.Lock()
+	h, err := s.getHash(args[1])
+	if err != nil {
+		s.hashLock.Unlock()
+		writeError(c.writer, err)
+		return
+	}
+	if h == nil {
+		h = &hash{fields: make(map[string]string)}
+		s.cache.PutWithTTL(args[1], h, 0)
+	}
+	s.hashLock.Unlock()
+
+	added := 0
+	h.lock.Lock()
+	for i := 2; i < len(args); i += 2 {
+		if _, exist := h.fields[args[i]]; !exist {
+			added++
+		}
+		h.fields[args[i]] = args[i+1]
+	}
+	h.lock.Unlock()
+	writeInt(c.writer, int64(added))
+}
+
+func (s *server) hexists(c *client, args []string) {
+	h, err := s.getHash(args[1])
+	if err != nil {
+		writeError(c.writer, err)
+		return
+	}
+	if h == nil {
+		writeInt(c.writer, 0)
+		return
+	}
+	h.lock.RLock()
+	_, exist := h.fields[args[2]]
+	h.lock.RUnlock()
+	if exist {
+		writeInt(c.writer, 1)
+	} else {
+		writeInt(c.writer, 0)
+	}
+}
+
+// key不存在返回-2，永不过期返回-1，否则返回剩余秒数（四舍五入）
+func (s *server) ttl(c *client, args []string) {
+	ttl, ok := s.cache.TTL(args[1])
+	switch {
+	case !ok:
+		writeInt(c.writer, -2)
+	case ttl == 0:
+		writeInt(c.writer, -1)
+	default:
+		writeInt(c.writer, int64((ttl+500*time.Millisecond)/time.Second))
+	}
+}
+
+func (s *server) dbsize(c *client, args []string) {
+	writeInt(c.writer, int64(s.cache.Len()))
+}
+
+func (s *server) flushall(c *client, args []string) {
+	s.cache.Purge()
+	writeSimple(c.writer, "OK")
+}
+
+// 读取一条命令。客户端一般发送RESP数组格式：*<参数个数>\r\n，每个参数是$<长度>\r\n<内容>\r\n；
+// 也支持telnet等工具直接发送的以空格分隔的内联命令。
+// 和redis一样限制一行的长度、一条命令的参数个数和每个参数的长度，认证之前的限制更小。
+// 长度来自客户端，参数的内容边读边分配内存，只发几个长度不发内容的客户端不能让服务端分配巨大的内存。
+const (
+	maxInlineLength          = 64 * 1024
+	maxMultibulkLength       = 1024 * 1024
+	maxBulkLength            = 512 * 1024 * 1024
+	maxUnauthMultibulkLength = 10
+	maxUnauthBulkLength      = 16 * 1024
+	bulkPreallocLength       = 64 * 1024 // 不超过这个长度的参数一次分配好内存
+)
+
+func readCommand(r *bufio.Reader, authenticated bool) ([]string, error) {
+	multibulkLimit, bulkLimit := maxMultibulkLength, maxBulkLength
+	if !authenticated {
+		multibulkLimit, bulkLimit = maxUnauthMultibulkLength, maxUnauthBulkLength
+	}
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '*' {
+		return strings.Fields(line), nil
+	}
+
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < 0 || n > multibulkLimit {
+		return nil, fmt.Errorf("invalid multibulk length")
+	}
+	args := make([]string, 0, min(n, 16))
+	for len(args) < n {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, fmt.Errorf("expected '$', got '%s'", line)
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 || size > bulkLimit {
+			return nil, fmt.Errorf("invalid bulk length")
+		}
+		var buf bytes.Buffer
+		if size <= bulkPreallocLength {
+			buf.Grow(size + 2)
+		}
+		if _, err := io.CopyN(&buf, r, int64(size)+2); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		data := buf.Bytes()
+		if data[size] != '\r' || data[size+1] != '\n' {
+			return nil, fmt.Errorf("bulk string not terminated by CRLF")
+		}
+		args = append(args, string(data[:size]))
+	}
+	return args, nil
+}
+
+// 读取一行，去掉结尾的\r\n。超过maxInlineLength还没有读到\n时返回错误
+func readLine(r *bufio.Reader) (string, error) {
+	var line []byte
+	for {
+		chunk, err := r.ReadSlice('\n')
+		// 读满maxInlineLength还没有读到\n时不再等待剩下的内容
+		if len(line)+len(chunk) > maxInlineLength || (err == bufio.ErrBufferFull && len(line)+len(chunk) == maxInlineLength) {
+			return "", fmt.Errorf("too big inline request")
+		}
+		line = append(line, chunk...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(line), "\r\n"), nil
+	}
+}
+
+func writeSimple(w *bufio.Writer, s string) {
+	w.WriteString("+" + s + "\r\n")
+}
+
+func writeError(w *bufio.Writer, err error) {
+	w.WriteString("-" + err.Error() + "\r\n")
+}
+
+func writeInt(w *bufio.Writer, n int64) {
+	w.WriteString(":" + strconv.FormatInt(n, 10) + "\r\n")
+}
+
+func writeBulk(w *bufio.Writer, s string) {
+	w.WriteString("$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n")
+}
+
+func writeNil(w *bufio.Writer) {
+	w.WriteString("$-1\r\n")
+}
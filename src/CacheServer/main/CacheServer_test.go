@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// 在本地随机端口启动服务，返回连接到它的redigo客户端
+func startServer(t *testing.T, password string, opts ...redis.DialOption) redis.Conn {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go newServer(100, password).serve(listener)
+
+	conn, err := redis.Dial("tcp", listener.Addr().String(), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestStringCommands(t *testing.T) {
+	conn := startServer(t, "")
+
+	if ok, err := redis.String(conn.Do("SET", "name", "kongjie")); err != nil || ok != "OK" {
+		t.Fatalf("SET = %q, %v", ok, err)
+	}
+	if v, err := redis.String(conn.Do("GET", "name")); err != nil || v != "kongjie" {
+		t.Fatalf("GET = %q, %v", v, err)
+	}
+	if _, err := redis.String(conn.Do("GET", "missing")); err != redis.ErrNil {
+		t.Fatalf("GET missing err = %v, want ErrNil", err)
+	}
+	if n, _ := redis.Int(conn.Do("EXISTS", "name", "missing", "name")); n != 2 {
+		t.Fatalf("EXISTS = %d, want 2", n)
+	}
+	if n, _ := redis.Int(conn.Do("DBSIZE")); n != 1 {
+		t.Fatalf("DBSIZE = %d, want 1", n)
+	}
+	if n, _ := redis.Int(conn.Do("DEL", "name", "missing")); n != 1 {
+		t.Fatalf("DEL = %d, want 1", n)
+	}
+	if n, _ := redis.Int(conn.Do("EXISTS", "name")); n != 0 {
+		t.Fatalf("EXISTS after DEL = %d, want 0", n)
+	}
+	if _, err := conn.Do("NOSUCHCMD"); err == nil {
+		t.Fatal("unknown command did not return an error")
+	}
+	if _, err := conn.Do("GET"); err == nil {
+		t.Fatal("GET without key did not return an error")
+	}
+}
+
+func TestExpire(t *testing.T) {
+	conn := startServer(t, "")
+
+	conn.Do("SET", "forever", "v")
+	conn.Do("SET", "ex", "v", "EX", 100)
+	conn.Do("SET", "px", "v", "PX", 20)
+	if n, _ := redis.Int(conn.Do("TTL", "forever")); n != -1 {
+		t.Fatalf("TTL forever = %d, want -1", n)
+	}
+	if n, _ := redis.Int(conn.Do("TTL", "ex")); n != 100 {
+		t.Fatalf("TTL ex = %d, want 100", n)
+	}
+	if n, _ := redis.Int(conn.Do("TTL", "missing")); n != -2 {
+		t.Fatalf("TTL missing = %d, want -2", n)
+	}
+	time.Sleep(40 * time.Millisecond)
+	if _, err := redis.String(conn.Do("GET", "px")); err != redis.ErrNil {
+		t.Fatalf("GET expired err = %v, want ErrNil", err)
+	}
+	if _, err := conn.Do("SET", "k", "v", "EX", "abc"); err == nil {
+		t.Fatal("SET with invalid EX did not return an error")
+	}
+}
+
+// 爬虫用HEXISTS和HSET记录已经爬取过的图片
+func TestHashCommands(t *testing.T) {
+	conn := startServer(t, "")
+
+	if n, _ := redis.Int(conn.Do("HEXISTS", "kongjie", "1:2")); n != 0 {
+		t.Fatalf("HEXISTS before HSET = %d", n)
+	}
+	if n, _ := redis.Int(conn.Do("HSET", "kongjie", "1:2", "1", "3:4", "1")); n != 2 {
+		t.Fatalf("HSET = %d, want 2", n)
+	}
+	if n, _ := redis.Int(conn.Do("HSET", "kongjie", "1:2", "2")); n != 0 {
+		t.Fatalf("HSET existing field = %d, want 0", n)
+	}
+	if n, _ := redis.Int(conn.Do("HEXISTS", "kongjie", "1:2")); n != 1 {
+		t.Fatalf("HEXISTS after HSET = %d", n)
+	}
+	if v, _ := redis.String(conn.Do("HGET", "kongjie", "1:2")); v != "2" {
+		t.Fatalf("HGET = %q, want 2", v)
+	}
+	if _, err := redis.String(conn.Do("HGET", "kongjie", "missing")); err != redis.ErrNil {
+		t.Fatalf("HGET missing field err = %v", err)
+	}
+
+	conn.Do("SET", "str", "v")
+	if _, err := conn.Do("HSET", "str", "f", "v"); err == nil {
+		t.Fatal("HSET on string key did not return WRONGTYPE")
+	}
+	if _, err := conn.Do("GET", "kongjie"); err == nil {
+		t.Fatal("GET on hash key did not return WRONGTYPE")
+	}
+
+	if ok, _ := redis.String(conn.Do("FLUSHALL")); ok != "OK" {
+		t.Fatalf("FLUSHALL = %q", ok)
+	}
+	if n, _ := redis.Int(conn.Do("DBSIZE")); n != 0 {
+		t.Fatalf("DBSIZE after FLUSHALL = %d", n)
+	}
+}
+
+func TestAuth(t *testing.T) {
+	conn := startServer(t, "flyvar", redis.DialPassword("flyvar"), redis.DialDatabase(0))
+	if v, err := redis.String(conn.Do("PING")); err != nil || v != "PONG" {
+		t.Fatalf("PING = %q, %v", v, err)
+	}
+
+	unauthenticated := startServer(t, "flyvar")
+	if _, err := unauthenticated.Do("GET", "k"); err == nil {
+		t.Fatal("GET without AUTH did not return NOAUTH")
+	}
+	if _, err := unauthenticated.Do("AUTH", "wrong"); err == nil {
+		t.Fatal("AUTH with wrong password succeeded")
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	conn := startServer(t, "")
+	for i := 0; i < 100; i++ {
+		conn.Send("SET", i, i)
+	}
+	conn.Send("DBSIZE")
+	conn.Flush()
+	for i := 0; i < 100; i++ {
+		if _, err := conn.Receive(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, _ := redis.Int(conn.Receive()); n != 100 {
+		t.Fatalf("DBSIZE = %d, want 100", n)
+	}
+}
+
+// 参数个数和长度超过限制时返回协议错误并断开连接，不能让服务端panic或者分配巨大的内存
+func TestProtocolLimits(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go newServer(100, "").serve(listener)
+	authListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer authListener.Close()
+	go newServer(100, "flyvar").serve(authListener)
+
+	tests := []struct {
+		addr    string
+		command string
+	}{
+		{listener.Addr().String(), "*1\r\n$9223372036854775807\r\n"},
+		{listener.Addr().String(), "*1\r\n$536870913\r\n"},
+		{listener.Addr().String(), "*1\r\n$-5\r\n"},
+		{listener.Addr().String(), "*9223372036854775807\r\n"},
+		{listener.Addr().String(), "*1048577\r\n"},
+		{listener.Addr().String(), "*-2\r\n"},
+		// 没有换行的内联命令
+		{listener.Addr().String(), strings.Repeat("a", maxInlineLength+1)},
+		{listener.Addr().String(), "*1\r\n" + strings.Repeat("$", maxInlineLength+1)},
+		// 认证之前只允许很小的命令
+		{authListener.Addr().String(), "*11\r\n"},
+		{authListener.Addr().String(), "*2\r\n$4\r\nAUTH\r\n$16385\r\n"},
+	}
+	for _, test := range tests {
+		conn, err := net.Dial("tcp", test.addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		if _, err := conn.Write([]byte(test.command)); err != nil {
+			t.Fatal(err)
+		}
+		reply, err := bufio.NewReader(conn).ReadString('\n')
+		conn.Close()
+		if err != nil || !strings.HasPrefix(reply, "-ERR Protocol error") {
+			t.Errorf("%.40q got reply %q, %v, want a protocol error", test.command, reply, err)
+		}
+	}
+
+	// 服务端还活着，认证之后可以发送大的参数
+	conn, err := redis.Dial("tcp", authListener.Addr().String(), redis.DialPassword("flyvar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if v, err := redis.String(conn.Do("PING")); err != nil || v != "PONG" {
+		t.Fatalf("PING after bad commands = %q, %v", v, err)
+	}
+	big := strings.Repeat("x", 3*bulkPreallocLength+7)
+	if _, err := conn.Do("SET", "big", big); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := redis.String(conn.Do("GET", "big")); err != nil || v != big {
+		t.Fatalf("GET big = %d bytes, %v, want %d bytes", len(v), err, len(big))
+	}
+}
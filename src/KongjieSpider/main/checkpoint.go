@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// 断点续爬：定期把frontier的状态保存到conf.Checkpoint.Path，进程被杀掉后用-resume启动，
+// 会从最后一次保存的位置继续爬取，已经完成的页面不会再请求。
+
+// 把frontier的状态写入path：先写临时文件、落盘后再重命名，保存到一半被杀掉也不会破坏上一次的checkpoint
+func saveCheckpoint(path string, f *frontier) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	err = json.NewEncoder(tmp).Encode(f.State())
+	// 先落盘再重命名，否则断电后可能留下一个重命名过但内容为空的checkpoint
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// 读取saveCheckpoint保存的状态
+func loadCheckpoint(path string) (frontierState, error) {
+	var state frontierState
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return state, err
+	}
+	err = json.Unmarshal(data, &state)
+	return state, err
+}
+
+// 每隔interval保存一次checkpoint，直到stop被关闭。每次保存成功后调用saved
+func runCheckpoints(path string, interval time.Duration, f *frontier, stop <-chan struct{}, saved func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := saveCheckpoint(path, f); err != nil {
+				log.Println("can not save checkpoint!", err)
+				continue
+			}
+			saved()
+			pending, inFlight := f.Len()
+			log.Printf("checkpoint saved, %d pending, %d in flight", pending, inFlight)
+		}
+	}
+}
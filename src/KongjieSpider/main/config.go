@@ -0,0 +1,386 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 爬虫的配置。优先级从低到高依次是：默认值、配置文件（YAML或JSON）、环境变量、命令行参数。
+type Config struct {
+	Concurrency int                 `yaml:"concurrency" json:"concurrency"` // 将会开启Concurrency个goroutine来爬取用户相册中所有图片
+	SaveFolder  string              `yaml:"saveFolder" json:"saveFolder"`   // 图片保存的文件夹
+	Site        string              `yaml:"site" json:"site"`               // 站点定义文件，为空表示使用内置的空姐网定义
+	StartURL    string              `yaml:"startUrl" json:"startUrl"`       // 第一个列表页，为空时使用站点定义中的
+	Seen        SeenConfig          `yaml:"seen" json:"seen"`               // 记录哪些图片已经爬取过
+	Redis       RedisConfig         `yaml:"redis" json:"redis"`
+	Headers     map[string][]string `yaml:"headers" json:"headers"`       // 每个请求都会带上的header，为空时使用站点定义中的
+	Timeout     Duration            `yaml:"timeout" json:"timeout"`       // 单个请求的超时时间，0表示不超时
+	Retry       RetryConfig         `yaml:"retry" json:"retry"`           // 请求失败时的重试策略
+	FailedFile  string              `yaml:"failedFile" json:"failedFile"` // 重试多次仍失败的任务记录到这个文件，为空表示不记录
+	Checkpoint  CheckpointConfig    `yaml:"checkpoint" json:"checkpoint"` // 断点续爬
+	Politeness  PolitenessConfig    `yaml:"politeness" json:"politeness"` // 对每个host的限速、并发数和robots.txt
+
+	configFile  string // 配置文件路径，只能通过命令行或环境变量指定
+	printConfig bool   // 打印最终生效的配置后退出
+	resume      bool   // 从checkpoint继续上一次的爬取
+	retryFailed bool   // 重新爬取FailedFile中记录的失败任务
+	site        *Site  // 从Site加载的站点定义
+}
+
+type SeenConfig struct {
+	Store    string `yaml:"store" json:"store"`       // redis、bolt或memory
+	Key      string `yaml:"key" json:"key"`           // redis的hash名，或者bolt的bucket名，为空时使用站点名
+	Path     string `yaml:"path" json:"path"`         // bolt数据库文件路径
+	Capacity int    `yaml:"capacity" json:"capacity"` // memory最多记住的key个数，0表示不限制
+}
+
+type RedisConfig struct {
+	Addr                string   `yaml:"addr" json:"addr"`
+	Password            string   `yaml:"password" json:"password"`
+	DB                  int      `yaml:"db" json:"db"`
+	MaxActive           int      `yaml:"maxActive" json:"maxActive"`                     // 连接池最多的连接数，0表示不限制
+	MaxIdle             int      `yaml:"maxIdle" json:"maxIdle"`                         // 连接池最多保留的空闲连接数
+	IdleTimeout         Duration `yaml:"idleTimeout" json:"idleTimeout"`                 // 空闲超过这个时间的连接会被关闭，0表示不关闭
+	HealthCheckInterval Duration `yaml:"healthCheckInterval" json:"healthCheckInterval"` // 空闲超过这个时间的连接借出前先PING一下
+	DialTimeout         Duration `yaml:"dialTimeout" json:"dialTimeout"`
+	ReadTimeout         Duration `yaml:"readTimeout" json:"readTimeout"`
+	WriteTimeout        Duration `yaml:"writeTimeout" json:"writeTimeout"`
+}
+
+type CheckpointConfig struct {
+	Path     string   `yaml:"path" json:"path"`         // 保存frontier状态的文件
+	Interval Duration `yaml:"interval" json:"interval"` // 保存的间隔，0表示只在退出时保存
+}
+
+type PolitenessConfig struct {
+	RatePerHost        float64 `yaml:"ratePerHost" json:"ratePerHost"`               // 每个host每秒最多发出的请求数，0表示不限速
+	BurstPerHost       int     `yaml:"burstPerHost" json:"burstPerHost"`             // 每个host最多可以连续发出的请求数
+	MaxInFlightPerHost int     `yaml:"maxInFlightPerHost" json:"maxInFlightPerHost"` // 每个host同时进行的请求数，0表示不限制
+	Robots             bool    `yaml:"robots" json:"robots"`                         // 遵守robots.txt中的Disallow和Crawl-delay
+	UserAgent          string  `yaml:"userAgent" json:"userAgent"`                   // 匹配robots.txt中User-agent的名字，为空时使用请求头中User-Agent的产品名
+}
+
+type RetryConfig struct {
+	MaxAttempts int      `yaml:"maxAttempts" json:"maxAttempts"` // 每个请求最多尝试的次数，包括第一次
+	BaseDelay   Duration `yaml:"baseDelay" json:"baseDelay"`     // 第一次重试前等待的时间，之后每次翻倍
+	MaxDelay    Duration `yaml:"maxDelay" json:"maxDelay"`       // 两次重试之间最多等待的时间
+}
+
+// 配置文件和命令行中以"1m30s"这样的字符串表示的时间间隔
+type Duration time.Duration
+
+func (d Duration) String() string { return time.Duration(d).String() }
+
+func (d Duration) MarshalText() ([]byte, error) { return []byte(d.String()), nil }
+
+func (d *Duration) UnmarshalText(text []byte) error { return d.Set(string(text)) }
+
+// 实现flag.Value
+func (d *Duration) Set(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// 环境变量名的前缀，例如KONGJIE_SAVE_FOLDER
+const envPrefix = "KONGJIE_"
+
+func defaultConfig() *Config {
+	return &Config{
+		Concurrency: 20,
+		SaveFolder:  "kongjiewang",
+		Seen:        SeenConfig{Store: "redis", Path: "kongjie.db"},
+		Redis: RedisConfig{
+			Addr:                "127.0.0.1:6379",
+			MaxActive:           20,
+			MaxIdle:             10,
+			IdleTimeout:         Duration(5 * time.Minute),
+			HealthCheckInterval: Duration(time.Minute),
+			DialTimeout:         Duration(5 * time.Second),
+			ReadTimeout:         Duration(3 * time.Second),
+			WriteTimeout:        Duration(3 * time.Second),
+		},
+		Timeout: Duration(30 * time.Second),
+		Retry: RetryConfig{
+			MaxAttempts: 4,
+			BaseDelay:   Duration(500 * time.Millisecond),
+			MaxDelay:    Duration(30 * time.Second),
+		},
+		FailedFile: "failed_urls.txt",
+		Checkpoint: CheckpointConfig{Path: "kongjie.checkpoint.json", Interval: Duration(30 * time.Second)},
+		Politeness: PolitenessConfig{RatePerHost: 5, BurstPerHost: 5, MaxInFlightPerHost: 4, Robots: true},
+	}
+}
+
+// 定义命令行参数，参数值直接写入conf，conf中已有的值作为默认值
+func newFlagSet(conf *Config) *flag.FlagSet {
+	fs := flag.NewFlagSet("kongjie", flag.ContinueOnError)
+	fs.StringVar(&conf.configFile, "config", conf.configFile, "配置文件路径，.json结尾按JSON解析，否则按YAML解析（环境变量"+envPrefix+"CONFIG）")
+	fs.BoolVar(&conf.printConfig, "print-config", conf.printConfig, "打印最终生效的配置后退出")
+	fs.BoolVar(&conf.resume, "resume", conf.resume, "从checkpoint文件继续上一次被中断的爬取")
+	fs.BoolVar(&conf.retryFailed, "retry-failed", conf.retryFailed, "重新爬取失败任务文件中记录的任务，不带-resume时只爬这些任务")
+	fs.IntVar(&conf.Concurrency, "concurrency", conf.Concurrency, "爬取图片的goroutine个数（环境变量"+envPrefix+"CONCURRENCY）")
+	fs.StringVar(&conf.SaveFolder, "save-folder", conf.SaveFolder, "图片保存的文件夹（环境变量"+envPrefix+"SAVE_FOLDER）")
+	fs.StringVar(&conf.Site, "site", conf.Site, "站点定义文件，为空表示爬取空姐网（环境变量"+envPrefix+"SITE）")
+	fs.StringVar(&conf.StartURL, "start-url", conf.StartURL, "第一个列表页，为空时使用站点定义中的（环境变量"+envPrefix+"START_URL）")
+	fs.StringVar(&conf.Seen.Store, "seen-store", conf.Seen.Store, "用redis、bolt还是memory记录已经爬取过的图片（环境变量"+envPrefix+"SEEN_STORE）")
+	fs.StringVar(&conf.Seen.Path, "seen-path", conf.Seen.Path, "seen-store为bolt时的数据库文件路径（环境变量"+envPrefix+"SEEN_PATH）")
+	fs.StringVar(&conf.Redis.Addr, "redis-addr", conf.Redis.Addr, "redis地址（环境变量"+envPrefix+"REDIS_ADDR）")
+	fs.StringVar(&conf.Redis.Password, "redis-password", conf.Redis.Password, "redis密码（环境变量"+envPrefix+"REDIS_PASSWORD）")
+	fs.IntVar(&conf.Redis.DB, "redis-db", conf.Redis.DB, "redis数据库（环境变量"+envPrefix+"REDIS_DB）")
+	fs.IntVar(&conf.Redis.MaxActive, "redis-max-active", conf.Redis.MaxActive, "redis连接池最多的连接数，0表示不限制（环境变量"+envPrefix+"REDIS_MAX_ACTIVE）")
+	fs.IntVar(&conf.Redis.MaxIdle, "redis-max-idle", conf.Redis.MaxIdle, "redis连接池最多保留的空闲连接数（环境变量"+envPrefix+"REDIS_MAX_IDLE）")
+	fs.Var(&conf.Timeout, "timeout", "单个请求的超时时间，0表示不超时（环境变量"+envPrefix+"TIMEOUT）")
+	fs.IntVar(&conf.Retry.MaxAttempts, "max-attempts", conf.Retry.MaxAttempts, "每个请求最多尝试的次数（环境变量"+envPrefix+"MAX_ATTEMPTS）")
+	fs.StringVar(&conf.Checkpoint.Path, "checkpoint", conf.Checkpoint.Path, "保存爬取进度的文件（环境变量"+envPrefix+"CHECKPOINT）")
+	fs.Var(&conf.Checkpoint.Interval, "checkpoint-interval", "保存爬取进度的间隔，0表示只在退出时保存（环境变量"+envPrefix+"CHECKPOINT_INTERVAL）")
+	fs.Float64Var(&conf.Politeness.RatePerHost, "rate-per-host", conf.Politeness.RatePerHost, "每个host每秒最多发出的请求数，0表示不限速（环境变量"+envPrefix+"RATE_PER_HOST）")
+	fs.IntVar(&conf.Politeness.BurstPerHost, "burst-per-host", conf.Politeness.BurstPerHost, "每个host最多可以连续发出的请求数（环境变量"+envPrefix+"BURST_PER_HOST）")
+	fs.IntVar(&conf.Politeness.MaxInFlightPerHost, "max-in-flight-per-host", conf.Politeness.MaxInFlightPerHost, "每个host同时进行的请求数，0表示不限制（环境变量"+envPrefix+"MAX_IN_FLIGHT_PER_HOST）")
+	fs.BoolVar(&conf.Politeness.Robots, "robots", conf.Politeness.Robots, "遵守robots.txt，-robots=false表示忽略（环境变量"+envPrefix+"ROBOTS）")
+	fs.StringVar(&conf.Politeness.UserAgent, "robots-user-agent", conf.Politeness.UserAgent, "匹配robots.txt中User-agent的名字，为空时使用请求头中User-Agent的产品名（环境变量"+envPrefix+"ROBOTS_USER_AGENT）")
+	fs.StringVar(&conf.FailedFile, "failed-file", conf.FailedFile, "记录最终失败的任务的文件，为空表示不记录（环境变量"+envPrefix+"FAILED_FILE）")
+	return fs
+}
+
+// 依次合并默认值、配置文件、环境变量和命令行参数，返回校验过的配置
+func loadConfig(args []string) (*Config, error) {
+	// 先解析一次命令行，只为了拿到配置文件路径，参数有错误时也在这里返回
+	conf := defaultConfig()
+	conf.configFile = os.Getenv(envPrefix + "CONFIG")
+	if err := newFlagSet(conf).Parse(args); err != nil {
+		return nil, err
+	}
+	configFile := conf.configFile
+
+	conf = defaultConfig()
+	if configFile != "" {
+		if err := conf.loadFile(configFile); err != nil {
+			return nil, err
+		}
+	}
+	if err := conf.loadEnv(os.LookupEnv); err != nil {
+		return nil, err
+	}
+	// 命令行参数覆盖前面所有的值，没有指定的参数保持原值
+	fs := newFlagSet(conf)
+	fs.SetOutput(io.Discard)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	conf.configFile = configFile
+
+	// 没有单独配置的起始页、header和seen.key使用站点定义中的，不同站点的图片不会记录在一起
+	site, err := loadSite(conf.Site)
+	if err != nil {
+		return nil, err
+	}
+	conf.site = site
+	if conf.StartURL == "" {
+		conf.StartURL = site.StartURL
+	}
+	if conf.Headers == nil {
+		conf.Headers = site.Headers
+	}
+	if conf.Seen.Key == "" {
+		conf.Seen.Key = site.Name
+	}
+
+	if err := conf.validate(); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+// 从YAML或JSON文件读取配置，文件中没有的字段保持原值
+func (conf *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = json.Unmarshal(data, conf)
+	} else {
+		err = yaml.Unmarshal(data, conf)
+	}
+	if err != nil {
+		return fmt.Errorf("parse config file %s: %v", path, err)
+	}
+	return nil
+}
+
+// 从环境变量读取配置，lookup一般是os.LookupEnv
+func (conf *Config) loadEnv(lookup func(string) (string, bool)) error {
+	stringFields := map[string]*string{
+		"SAVE_FOLDER":       &conf.SaveFolder,
+		"SITE":              &conf.Site,
+		"START_URL":         &conf.StartURL,
+		"SEEN_STORE":        &conf.Seen.Store,
+		"SEEN_PATH":         &conf.Seen.Path,
+		"REDIS_ADDR":        &conf.Redis.Addr,
+		"REDIS_PASSWORD":    &conf.Redis.Password,
+		"FAILED_FILE":       &conf.FailedFile,
+		"CHECKPOINT":        &conf.Checkpoint.Path,
+		"ROBOTS_USER_AGENT": &conf.Politeness.UserAgent,
+	}
+	for name, field := range stringFields {
+		if v, ok := lookup(envPrefix + name); ok {
+			*field = v
+		}
+	}
+	intFields := map[string]*int{
+		"CONCURRENCY":            &conf.Concurrency,
+		"REDIS_DB":               &conf.Redis.DB,
+		"MAX_ATTEMPTS":           &conf.Retry.MaxAttempts,
+		"REDIS_MAX_ACTIVE":       &conf.Redis.MaxActive,
+		"REDIS_MAX_IDLE":         &conf.Redis.MaxIdle,
+		"BURST_PER_HOST":         &conf.Politeness.BurstPerHost,
+		"MAX_IN_FLIGHT_PER_HOST": &conf.Politeness.MaxInFlightPerHost,
+	}
+	for name, field := range intFields {
+		if v, ok := lookup(envPrefix + name); ok {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("invalid %s%s=%q: %v", envPrefix, name, v, err)
+			}
+			*field = n
+		}
+	}
+	floatFields := map[string]*float64{
+		"RATE_PER_HOST": &conf.Politeness.RatePerHost,
+	}
+	for name, field := range floatFields {
+		if v, ok := lookup(envPrefix + name); ok {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return fmt.Errorf("invalid %s%s=%q: %v", envPrefix, name, v, err)
+			}
+			*field = f
+		}
+	}
+	boolFields := map[string]*bool{
+		"ROBOTS": &conf.Politeness.Robots,
+	}
+	for name, field := range boolFields {
+		if v, ok := lookup(envPrefix + name); ok {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return fmt.Errorf("invalid %s%s=%q: %v", envPrefix, name, v, err)
+			}
+			*field = b
+		}
+	}
+	durationFields := map[string]*Duration{
+		"TIMEOUT":             &conf.Timeout,
+		"CHECKPOINT_INTERVAL": &conf.Checkpoint.Interval,
+	}
+	for name, field := range durationFields {
+		if v, ok := lookup(envPrefix + name); ok {
+			if err := field.Set(v); err != nil {
+				return fmt.Errorf("invalid %s%s=%q: %v", envPrefix, name, v, err)
+			}
+		}
+	}
+	return nil
+}
+
+// 检查配置是否合法，一次返回所有的错误
+func (conf *Config) validate() error {
+	var errs []error
+	if conf.Concurrency <= 0 {
+		errs = append(errs, fmt.Errorf("concurrency must be positive, got %d", conf.Concurrency))
+	}
+	if conf.SaveFolder == "" {
+		errs = append(errs, errors.New("saveFolder must not be empty"))
+	}
+	if u, err := url.Parse(conf.StartURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, fmt.Errorf("startUrl must be an absolute http(s) url, got %q", conf.StartURL))
+	}
+	switch conf.Seen.Store {
+	case "redis":
+		if _, _, err := net.SplitHostPort(conf.Redis.Addr); err != nil {
+			errs = append(errs, fmt.Errorf("redis.addr must be host:port, got %q", conf.Redis.Addr))
+		}
+		if conf.Redis.DB < 0 {
+			errs = append(errs, fmt.Errorf("redis.db must not be negative, got %d", conf.Redis.DB))
+		}
+		if conf.Redis.MaxActive < 0 || conf.Redis.MaxIdle < 0 {
+			errs = append(errs, fmt.Errorf("redis pool sizes must not be negative, got maxActive %d and maxIdle %d", conf.Redis.MaxActive, conf.Redis.MaxIdle))
+		}
+		if conf.Redis.IdleTimeout < 0 || conf.Redis.HealthCheckInterval < 0 || conf.Redis.DialTimeout < 0 ||
+			conf.Redis.ReadTimeout < 0 || conf.Redis.WriteTimeout < 0 {
+			errs = append(errs, errors.New("redis timeouts must not be negative"))
+		}
+	case "bolt":
+		if conf.Seen.Path == "" {
+			errs = append(errs, errors.New("seen.path must not be empty when seen.store is bolt"))
+		}
+	case "memory":
+		if conf.Seen.Capacity < 0 {
+			errs = append(errs, fmt.Errorf("seen.capacity must not be negative, got %d", conf.Seen.Capacity))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("seen.store must be redis, bolt or memory, got %q", conf.Seen.Store))
+	}
+	if conf.Seen.Key == "" {
+		errs = append(errs, errors.New("seen.key must not be empty when the site has no name"))
+	}
+	if conf.Timeout < 0 {
+		errs = append(errs, fmt.Errorf("timeout must not be negative, got %v", conf.Timeout))
+	}
+	if conf.Checkpoint.Path == "" {
+		errs = append(errs, errors.New("checkpoint.path must not be empty"))
+	}
+	if conf.Checkpoint.Interval < 0 {
+		errs = append(errs, fmt.Errorf("checkpoint.interval must not be negative, got %v", conf.Checkpoint.Interval))
+	}
+	if conf.Retry.MaxAttempts <= 0 {
+		errs = append(errs, fmt.Errorf("retry.maxAttempts must be positive, got %d", conf.Retry.MaxAttempts))
+	}
+	if conf.Retry.BaseDelay < 0 || conf.Retry.MaxDelay < conf.Retry.BaseDelay {
+		errs = append(errs, fmt.Errorf("retry delays must satisfy 0 <= baseDelay <= maxDelay, got %v and %v", conf.Retry.BaseDelay, conf.Retry.MaxDelay))
+	}
+	if conf.Politeness.RatePerHost < 0 || math.IsNaN(conf.Politeness.RatePerHost) || math.IsInf(conf.Politeness.RatePerHost, 0) {
+		errs = append(errs, fmt.Errorf("politeness.ratePerHost must be a non-negative number, got %v", conf.Politeness.RatePerHost))
+	}
+	if conf.Politeness.RatePerHost > 0 && conf.Politeness.BurstPerHost <= 0 {
+		errs = append(errs, fmt.Errorf("politeness.burstPerHost must be positive when ratePerHost is set, got %d", conf.Politeness.BurstPerHost))
+	}
+	if conf.Politeness.MaxInFlightPerHost < 0 {
+		errs = append(errs, fmt.Errorf("politeness.maxInFlightPerHost must not be negative, got %d", conf.Politeness.MaxInFlightPerHost))
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid config: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// 以YAML格式打印配置，密码用*代替
+func (conf *Config) print(w io.Writer) error {
+	printed := *conf
+	if printed.Redis.Password != "" {
+		printed.Redis.Password = "******"
+	}
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&printed); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
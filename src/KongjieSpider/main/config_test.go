@@ -0,0 +1,214 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 不带任何参数时使用默认值和内置站点定义，配置必须是合法的
+func TestLoadConfigDefaults(t *testing.T) {
+	conf, err := loadConfig(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	site, err := loadSite("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.StartURL != site.StartURL || !reflect.DeepEqual(conf.Headers, site.Headers) {
+		t.Errorf("startUrl and headers were not taken from the builtin site: %q %v", conf.StartURL, conf.Headers)
+	}
+	want := defaultConfig()
+	want.Seen.Key = "kongjie"
+	if conf.Seen != want.Seen || conf.Redis != want.Redis {
+		t.Errorf("seen %+v and redis %+v differ from the defaults %+v and %+v", conf.Seen, conf.Redis, want.Seen, want.Redis)
+	}
+	if conf.Seen.Store != "redis" || conf.Redis.Addr == "" {
+		t.Errorf("default seen store is %q at %q, want redis", conf.Seen.Store, conf.Redis.Addr)
+	}
+}
+
+// 把content写到临时目录下的name文件，返回文件路径
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// 每一项配置都由优先级最高的来源决定：默认值 < 配置文件 < 环境变量 < 命令行参数
+func TestLoadConfigPrecedence(t *testing.T) {
+	yamlFile := writeConfigFile(t, "kongjie.yaml", `
+concurrency: 5
+saveFolder: /from/file
+timeout: 10s
+failedFile: file.txt
+redis:
+  addr: file:6379
+politeness:
+  ratePerHost: 2
+`)
+	t.Setenv(envPrefix+"CONFIG", yamlFile)
+	t.Setenv(envPrefix+"SAVE_FOLDER", "/from/env")
+	t.Setenv(envPrefix+"TIMEOUT", "20s")
+	t.Setenv(envPrefix+"REDIS_ADDR", "env:6379")
+
+	conf, err := loadConfig([]string{"-timeout", "40s", "-redis-addr", "flag:6379"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		got, want any
+	}{
+		{"checkpoint.path (default)", conf.Checkpoint.Path, defaultConfig().Checkpoint.Path},
+		{"concurrency (file)", conf.Concurrency, 5},
+		{"failedFile (file)", conf.FailedFile, "file.txt"},
+		{"politeness.ratePerHost (file)", conf.Politeness.RatePerHost, 2.0},
+		{"saveFolder (env over file)", conf.SaveFolder, "/from/env"},
+		{"timeout (flag over env and file)", conf.Timeout, Duration(40 * time.Second)},
+		{"redis.addr (flag over env and file)", conf.Redis.Addr, "flag:6379"},
+		{"config file", conf.configFile, yamlFile},
+	}
+	for _, test := range tests {
+		if !reflect.DeepEqual(test.got, test.want) {
+			t.Errorf("%s = %v, want %v", test.name, test.got, test.want)
+		}
+	}
+
+	// -config覆盖环境变量中的配置文件，.json结尾的按JSON解析
+	jsonFile := writeConfigFile(t, "kongjie.json", `{"concurrency": 7, "seen": {"store": "memory", "key": "k"}}`)
+	conf, err = loadConfig([]string{"-config", jsonFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.Concurrency != 7 || conf.Seen.Store != "memory" || conf.SaveFolder != "/from/env" {
+		t.Errorf("json config gave concurrency %d, seen.store %q, saveFolder %q, want 7, memory and /from/env",
+			conf.Concurrency, conf.Seen.Store, conf.SaveFolder)
+	}
+}
+
+// 没有配置seen.key时使用站点名，不同站点不会共用一个记录
+func TestLoadConfigSeenKey(t *testing.T) {
+	site := `
+startUrl: http://bbs.example.com/album.php?page=1
+list:
+  item: {selector: "ul.albums a.cover", attr: href}
+detail:
+  image: {selector: "#photo img", attr: src}
+id:
+  pattern: aid=(\d+)
+  key: example-${1}
+`
+	named := writeConfigFile(t, "example.yaml", "name: example\n"+site)
+	unnamed := writeConfigFile(t, "unnamed.yaml", site)
+	keyFile := writeConfigFile(t, "kongjie.yaml", "seen:\n  key: mine\n")
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"-site", named}, "example"},
+		{[]string{"-site", named, "-config", keyFile}, "mine"},
+		{[]string{"-site", unnamed, "-config", keyFile}, "mine"},
+	}
+	for _, test := range tests {
+		conf, err := loadConfig(test.args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if conf.Seen.Key != test.want {
+			t.Errorf("loadConfig(%q) seen.key = %q, want %q", test.args, conf.Seen.Key, test.want)
+		}
+	}
+
+	if _, err := loadConfig([]string{"-site", unnamed}); err == nil || !strings.Contains(err.Error(), "seen.key") {
+		t.Errorf("site without a name and seen.key error = %v", err)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		file string
+		args []string
+		want []string // 错误信息中必须包含的内容
+	}{
+		{
+			name: "bad env",
+			env:  map[string]string{envPrefix + "CONCURRENCY": "many"},
+			want: []string{`invalid KONGJIE_CONCURRENCY="many"`},
+		},
+		{
+			name: "bad env duration",
+			env:  map[string]string{envPrefix + "TIMEOUT": "soon"},
+			want: []string{`invalid KONGJIE_TIMEOUT="soon"`},
+		},
+		{
+			name: "bad file",
+			file: "concurrency: [1, 2]\n",
+			want: []string{"parse config file"},
+		},
+		{
+			name: "missing file",
+			args: []string{"-config", filepath.Join(t.TempDir(), "missing.yaml")},
+			want: []string{"missing.yaml"},
+		},
+		{
+			name: "unknown flag",
+			args: []string{"-no-such-flag"},
+			want: []string{"no-such-flag"},
+		},
+		{
+			// 所有的错误一次返回
+			name: "invalid values",
+			file: "retry:\n  baseDelay: 2s\n  maxDelay: 1s\n",
+			env:  map[string]string{envPrefix + "SEEN_STORE": "mysql"},
+			args: []string{"-concurrency", "0", "-start-url", "ftp://www.kongjie.com/", "-max-attempts", "0"},
+			want: []string{
+				"invalid config",
+				"concurrency must be positive, got 0",
+				`startUrl must be an absolute http(s) url, got "ftp://www.kongjie.com/"`,
+				`seen.store must be redis, bolt or memory, got "mysql"`,
+				"retry.maxAttempts must be positive, got 0",
+				"retry delays must satisfy 0 <= baseDelay <= maxDelay, got 2s and 1s",
+			},
+		},
+		{
+			name: "bad redis addr",
+			env:  map[string]string{envPrefix + "REDIS_ADDR": "localhost"},
+			want: []string{`redis.addr must be host:port, got "localhost"`},
+		},
+		{
+			name: "bolt without path",
+			args: []string{"-seen-store", "bolt", "-seen-path", ""},
+			want: []string{"seen.path must not be empty when seen.store is bolt"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for k, v := range test.env {
+				t.Setenv(k, v)
+			}
+			if test.file != "" {
+				t.Setenv(envPrefix+"CONFIG", writeConfigFile(t, "kongjie.yaml", test.file))
+			}
+			_, err := loadConfig(test.args)
+			if err == nil {
+				t.Fatal("loadConfig succeeded, want an error")
+			}
+			for _, want := range test.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not contain %q", err, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"net/url"
+	"strings"
+
+	"github.com/andybalholm/cascadia"
+	"golang.org/x/net/html"
+)
+
+// 从页面中提取链接：页面先用golang.org/x/net/html解析成DOM树，每一项要提取的内容都在站点定义中用CSS选择器声明，
+// 属性的顺序、换行和空格变化都不影响提取。提取出的链接会按页面url转换成绝对链接。
+
+// 一条提取规则：用Selector选出元素，取元素的Attr属性，Attr为空时取元素的文本
+type extractRule struct {
+	Selector string `yaml:"selector"`
+	Attr     string `yaml:"attr"`
+	sel      cascadia.Matcher
+}
+
+func (r *extractRule) compile() error {
+	sel, err := cascadia.Compile(r.Selector)
+	if err != nil {
+		return err
+	}
+	r.sel = sel
+	return nil
+}
+
+// 页面中的<base href>，相对链接以它为准
+var baseRule = extractRule{Attr: "href", sel: cascadia.MustCompile(`head base[href]`)}
+
+// 返回所有匹配元素的值，跳过空值
+func (r extractRule) all(doc *html.Node) []string {
+	if r.sel == nil {
+		return nil
+	}
+	var values []string
+	for _, n := range cascadia.QueryAll(doc, r.sel) {
+		if v := r.value(n); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// 返回第一个匹配元素的值，没有时返回空字符串
+func (r extractRule) first(doc *html.Node) string {
+	if values := r.all(doc); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func (r extractRule) value(n *html.Node) string {
+	if r.Attr == "" {
+		return strings.TrimSpace(nodeText(n))
+	}
+	for _, attr := range n.Attr {
+		if attr.Key == r.Attr {
+			return strings.TrimSpace(attr.Val)
+		}
+	}
+	return ""
+}
+
+func nodeText(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var text strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		text.WriteString(nodeText(c))
+	}
+	return text.String()
+}
+
+// 列表页中提取出的内容
+type listPage struct {
+	Items []string `json:"items"` // 详情页链接
+	Next  string   `json:"next"`  // 下一个列表页，最后一页时为空
+}
+
+// 详情页中提取出的内容
+type detailPage struct {
+	Image string `json:"image"` // 图片链接
+	Next  string `json:"next"`  // 下一个详情页，最后一张时为空
+}
+
+func (site *Site) extractListPage(pageUrl string, content []byte) (listPage, error) {
+	doc, base, err := parsePage(pageUrl, content)
+	if err != nil {
+		return listPage{}, err
+	}
+	containers := []*html.Node{doc}
+	if site.List.container != nil {
+		containers = cascadia.QueryAll(doc, site.List.container)
+	}
+	var page listPage
+	for _, container := range containers {
+		for _, item := range site.List.Item.all(container) {
+			page.Items = append(page.Items, resolve(base, item))
+		}
+	}
+	page.Next = resolve(base, site.List.Next.first(doc))
+	return page, nil
+}
+
+func (site *Site) extractDetailPage(pageUrl string, content []byte) (detailPage, error) {
+	doc, base, err := parsePage(pageUrl, content)
+	if err != nil {
+		return detailPage{}, err
+	}
+	return detailPage{
+		Image: resolve(base, site.Detail.Image.first(doc)),
+		Next:  resolve(base, site.Detail.Next.first(doc)),
+	}, nil
+}
+
+// 解析utf8编码的html，页面中有<base href>时相对链接以它为准
+func parsePage(pageUrl string, content []byte) (*html.Node, *url.URL, error) {
+	base, err := url.Parse(pageUrl)
+	if err != nil {
+		return nil, nil, err
+	}
+	doc, err := html.Parse(bytes.NewReader(content))
+	if err != nil {
+		return nil, nil, err
+	}
+	if href := baseRule.first(doc); href != "" {
+		if u, err := base.Parse(href); err == nil {
+			base = u
+		}
+	}
+	return doc, base, nil
+}
+
+// 把页面中的链接转换成去掉#锚点的绝对链接，空链接和无法解析的链接返回空字符串
+func resolve(base *url.URL, ref string) string {
+	if ref == "" {
+		return ""
+	}
+	u, err := base.Parse(ref)
+	if err != nil {
+		return ""
+	}
+	// #后面的部分不会发给服务端，去掉后同一个页面的url才能去重
+	u.Fragment = ""
+	return u.String()
+}
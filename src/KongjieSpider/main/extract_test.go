@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+// 按内置的站点定义提取。页面是只保留了选择器用到的结构的片段，不是从空姐网保存的页面
+func TestExtractListPage(t *testing.T) {
+	site, err := loadSite("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := []byte(`<html><head><base href="http://www.kongjie.com/" /></head><body>
+<div class="ptw"><ul class="ml mlp cl">
+<li class="d"><div class="c"><a href="home.php?mod=space&amp;uid=1&amp;do=album&amp;picid=11"><img src="1.jpg" /></a></div>
+  <p class="ptm"><a href="home.php?mod=space&amp;uid=1">user</a></p></li>
+<li class="d"><div class="c"><a title="t" target="_blank"
+  href="home.php?mod=space&amp;uid=2&amp;do=album&amp;picid=22"><img src="2.jpg" /></a></div></li>
+<li class="d"><div class="c"><a href="http://www.kongjie.com/home.php?mod=space&amp;uid=3&amp;do=album&amp;picid=33#top"></a></div></li>
+</ul></div>
+<div class="pgs cl mtm"><div class="pg"><strong>1</strong>
+  <a href="home.php?mod=space&amp;do=album&amp;page=2">2</a>
+  <a class="nxt" href="home.php?mod=space&amp;do=album&amp;page=2">next</a></div></div>
+<div class="sd"><ul class="ml mlp cl"><li class="d"><div class="c"><a href="home.php?mod=space&amp;uid=9&amp;do=album&amp;picid=99"></a></div></li></ul></div>
+</body></html>`)
+
+	// 属性顺序不同、绝对链接和锚点都能处理，侧栏中的相册不在container中
+	page, err := site.extractListPage("http://www.kongjie.com/home.php?mod=space&do=album&page=1", content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := listPage{
+		Items: []string{
+			"http://www.kongjie.com/home.php?mod=space&uid=1&do=album&picid=11",
+			"http://www.kongjie.com/home.php?mod=space&uid=2&do=album&picid=22",
+			"http://www.kongjie.com/home.php?mod=space&uid=3&do=album&picid=33",
+		},
+		Next: "http://www.kongjie.com/home.php?mod=space&do=album&page=2",
+	}
+	if !reflect.DeepEqual(page, want) {
+		t.Errorf("extractListPage = %+v, want %+v", page, want)
+	}
+
+	// 最后一页没有下一页
+	page, err = site.extractListPage("http://www.kongjie.com/home.php?mod=space&do=album&page=9", []byte(`<div class="pgs cl mtm"><div class="pg"><strong>9</strong></div></div>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Items) != 0 || page.Next != "" {
+		t.Errorf("extractListPage on the last empty page = %+v, want nothing", page)
+	}
+}
+
+func TestExtractDetailPage(t *testing.T) {
+	site, err := loadSite("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pageUrl := "http://www.kongjie.com/home.php?mod=space&uid=1&do=album&picid=11"
+	tests := []struct {
+		name    string
+		content string
+		want    detailPage
+	}{
+		{"middle", `<div class="pns mlnv vm mtm cl">
+<a href="home.php?mod=space&amp;uid=1&amp;do=album&amp;picid=10&amp;goto=up#pic_block" class="btn" title="上一张">prev</a>
+<a class="btn" title="下一张" href="home.php?mod=space&amp;uid=1&amp;do=album&amp;picid=12&amp;goto=down#pic_block">next</a>
+</div>
+<div id="photo_pic" class="c"><a href="#"><img src="data/attachment/album/11.jpg?x=1&amp;y=2" id="pic" alt="" /></a></div>`,
+			detailPage{
+				Image: "http://www.kongjie.com/data/attachment/album/11.jpg?x=1&y=2",
+				Next:  "http://www.kongjie.com/home.php?mod=space&uid=1&do=album&picid=12&goto=down",
+			}},
+		{"last", `<div class="pns mlnv vm mtm cl">
+<a href="home.php?mod=space&amp;uid=1&amp;do=album&amp;picid=10&amp;goto=up" class="btn" title="上一张">prev</a>
+</div>
+<div class="c" id="photo_pic"><img id="pic" src="/data/attachment/album/11.png" /></div>`,
+			detailPage{Image: "http://www.kongjie.com/data/attachment/album/11.png"}},
+		// 不在#photo_pic中的图片不是要保存的图片
+		{"no image", `<div class="c"><img id="pic" src="ad.gif" /></div>`, detailPage{}},
+	}
+	for _, test := range tests {
+		page, err := site.extractDetailPage(pageUrl, []byte(test.content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if page != test.want {
+			t.Errorf("%s: extractDetailPage = %+v, want %+v", test.name, page, test.want)
+		}
+	}
+}
+
+func TestToUTF8(t *testing.T) {
+	page := `<html><head><meta http-equiv="Content-Type" content="text/html; charset=gbk" /><title>相册 - 空姐网</title></head></html>`
+	content, err := simplifiedchinese.GBK.NewEncoder().Bytes([]byte(page))
+	if err != nil {
+		t.Fatal(err)
+	}
+	utf8Content, err := toUTF8(content, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(utf8Content, []byte("<title>相册 - 空姐网</title>")) {
+		t.Fatalf("gbk page was not converted to utf8: %q", utf8Content)
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// HTTP请求：临时性错误（网络错误、5xx、429、408）按指数退避加随机抖动重试，
+// 服务端返回Retry-After时按它的要求等待，但不超过conf.Retry.MaxDelay。
+// 重试多次仍失败的任务记录到conf.FailedFile，用-retry-failed启动时重新爬取。
+
+// 发请求用的client，在main中按配置设置超时时间
+var httpClient = http.DefaultClient
+
+// 按host限速、限制并发和遵守robots.txt，在main中按配置创建，默认不做限制
+var polite = newPoliteness(PolitenessConfig{}, http.DefaultClient, nil)
+
+// 响应的状态码不是2xx
+type statusError struct {
+	url        string
+	statusCode int
+	retryAfter time.Duration // 响应头中的Retry-After，没有时为0
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("GET %s: %s", e.url, http.StatusText(e.statusCode))
+}
+
+// 发送带conf.Headers的GET请求，并用handle处理响应。
+// 请求或者handle返回临时性错误时会重新请求，最多尝试conf.Retry.MaxAttempts次。
+// 被robots.txt禁止的url直接返回errDisallowed，不算失败；robots.txt获取不到时返回errRobotsUnavailable，算作失败。
+func fetch(url string, handle func(res *http.Response) error) error {
+	if err := polite.check(url); err != nil {
+		return fmt.Errorf("GET %s: %w", url, err)
+	}
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = fetchOnce(url, handle)
+		if err == nil || !retryable(err) || attempt >= conf.Retry.MaxAttempts {
+			break
+		}
+		delay := backoff(attempt, err)
+		log.Printf("GET %s failed (attempt %d/%d), retry in %v: %v", url, attempt, conf.Retry.MaxAttempts, delay, err)
+		time.Sleep(delay)
+	}
+	if err != nil {
+		log.Println("give up", url, err)
+	}
+	return err
+}
+
+func fetchOnce(url string, handle func(res *http.Response) error) error {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	for k, v := range conf.Headers {
+		for _, val := range v {
+			req.Header.Add(k, val)
+		}
+	}
+
+	// 等到host允许时再发请求，处理完响应才释放，handle读取响应的过程也算在并发数里
+	release := polite.wait(req.URL)
+	defer release()
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// 读完剩下的内容，连接才能复用
+		_, _ = io.Copy(io.Discard, res.Body)
+		if err := res.Body.Close(); err != nil {
+			log.Println("error happened when closing response body!", err)
+		}
+	}()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return &statusError{url: url, statusCode: res.StatusCode, retryAfter: parseRetryAfter(res.Header.Get("Retry-After"))}
+	}
+	return handle(res)
+}
+
+// 判断错误是否是临时性的，重试可能成功
+func retryable(err error) bool {
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		code := statusErr.statusCode
+		return code >= 500 || code == http.StatusTooManyRequests || code == http.StatusRequestTimeout
+	}
+	// *url.Error本身也实现了net.Error，要看它包装的错误，否则url不合法之类的错误也会被重试
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		err = urlErr.Err
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// 第attempt次失败后等待的时间：conf.Retry.BaseDelay*2^(attempt-1)，不超过conf.Retry.MaxDelay，
+// 再在[delay/2, delay]之间随机取值，避免多个goroutine同时重试。
+// 服务端要求的Retry-After优先，但同样不超过conf.Retry.MaxDelay，服务端不能让worker无限期地等下去。
+func backoff(attempt int, err error) time.Duration {
+	var statusErr *statusError
+	if errors.As(err, &statusErr) && statusErr.retryAfter > 0 {
+		return min(statusErr.retryAfter, time.Duration(conf.Retry.MaxDelay))
+	}
+	delay := time.Duration(conf.Retry.MaxDelay)
+	if shift := attempt - 1; shift < 32 {
+		if d := time.Duration(conf.Retry.BaseDelay) << shift; d > 0 && d < delay {
+			delay = d
+		}
+	}
+	if delay <= 1 {
+		return delay
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// 解析Retry-After响应头，可以是秒数也可以是HTTP时间，无法解析时返回0
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(strings.TrimSpace(value)); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// 串行写入失败任务文件
+var failedLock sync.Mutex
+
+// 把最终失败的任务追加到conf.FailedFile，每行是任务类型、url和错误信息，用tab分隔
+func recordFailure(t task, err error) {
+	if conf.FailedFile == "" {
+		return
+	}
+	failedLock.Lock()
+	defer failedLock.Unlock()
+	f, openErr := os.OpenFile(conf.FailedFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if openErr != nil {
+		log.Println("can not record failed task!", openErr)
+		return
+	}
+	defer f.Close()
+	msg := strings.ReplaceAll(err.Error(), "\n", " ")
+	if _, writeErr := fmt.Fprintf(f, "%s\t%s\t%s\n", t.Kind, t.URL, msg); writeErr != nil {
+		log.Println("can not record failed task!", writeErr)
+	}
+}
+
+// 读取recordFailure记录的任务，格式不对的行跳过。同时返回读取的字节数，
+// 这些记录在重新爬取的任务保存进checkpoint之后用dropFailures删除
+func loadFailures(path string) ([]task, int64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	var tasks []task
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.SplitN(line, "\t", 3)
+		if len(fields) < 2 || fields[1] == "" {
+			continue
+		}
+		kind, ok := parseTaskKind(fields[0])
+		if !ok {
+			continue
+		}
+		tasks = append(tasks, task{Kind: kind, URL: fields[1]})
+	}
+	return tasks, int64(len(data)), nil
+}
+
+// 删除path开头n字节中的记录，保留之后recordFailure追加的记录。
+// 先写临时文件再重命名，和recordFailure串行执行
+func dropFailures(path string, n int64) error {
+	failedLock.Lock()
+	defer failedLock.Unlock()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if n > int64(len(data)) {
+		n = int64(len(data))
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data[n:])
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// 按rc替换全局配置中的重试策略，测试结束后恢复
+func useRetry(t *testing.T, rc RetryConfig) {
+	old := conf
+	conf = defaultConfig()
+	conf.Retry = rc
+	conf.FailedFile = ""
+	t.Cleanup(func() { conf = old })
+}
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&statusError{statusCode: http.StatusInternalServerError}, true},
+		{&statusError{statusCode: http.StatusServiceUnavailable}, true},
+		{&statusError{statusCode: http.StatusTooManyRequests}, true},
+		{&statusError{statusCode: http.StatusRequestTimeout}, true},
+		{&statusError{statusCode: http.StatusNotFound}, false},
+		{&statusError{statusCode: http.StatusForbidden}, false},
+		{fmt.Errorf("GET a: %w", io.ErrUnexpectedEOF), true},
+		{syscall.ECONNRESET, true},
+		{&net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, true},
+		{errors.New("html: bad page"), false},
+	}
+	for _, test := range tests {
+		if got := retryable(test.err); got != test.want {
+			t.Errorf("retryable(%v) = %v, want %v", test.err, got, test.want)
+		}
+	}
+
+	// 不合法的url被包装成*url.Error，不能因为*url.Error实现了net.Error就重试
+	_, err := http.Get("ht tp://bad url")
+	if err == nil || retryable(err) {
+		t.Errorf("retryable(%v) = true, want false", err)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	useRetry(t, RetryConfig{MaxAttempts: 10, BaseDelay: Duration(100 * time.Millisecond), MaxDelay: Duration(time.Second)})
+
+	// 每次翻倍，带抖动时在[delay/2, delay]之间，不超过MaxDelay
+	for attempt, want := range map[int]time.Duration{
+		1:  100 * time.Millisecond,
+		2:  200 * time.Millisecond,
+		4:  800 * time.Millisecond,
+		5:  time.Second,
+		40: time.Second,
+	} {
+		for i := 0; i < 20; i++ {
+			if got := backoff(attempt, errors.New("eof")); got < want/2 || got > want {
+				t.Fatalf("backoff(%d) = %v, want between %v and %v", attempt, got, want/2, want)
+			}
+		}
+	}
+
+	// Retry-After优先，但不超过MaxDelay
+	if got := backoff(1, &statusError{statusCode: 503, retryAfter: 300 * time.Millisecond}); got != 300*time.Millisecond {
+		t.Errorf("backoff with Retry-After 300ms = %v", got)
+	}
+	if got := backoff(1, &statusError{statusCode: 503, retryAfter: time.Hour}); got != time.Second {
+		t.Errorf("backoff with Retry-After 1h = %v, want clamped to 1s", got)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		value    string
+		min, max time.Duration
+	}{
+		{"", 0, 0},
+		{"120", 120 * time.Second, 120 * time.Second},
+		{" 3 ", 3 * time.Second, 3 * time.Second},
+		{"0", 0, 0},
+		{"-5", 0, 0},
+		{"soon", 0, 0},
+		{time.Now().Add(time.Minute).UTC().Format(http.TimeFormat), 58 * time.Second, time.Minute},
+		{time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat), 0, 0},
+	}
+	for _, test := range tests {
+		if got := parseRetryAfter(test.value); got < test.min || got > test.max {
+			t.Errorf("parseRetryAfter(%q) = %v, want between %v and %v", test.value, got, test.min, test.max)
+		}
+	}
+}
+
+func TestFetchRetry(t *testing.T) {
+	useRetry(t, RetryConfig{MaxAttempts: 3, BaseDelay: Duration(time.Millisecond), MaxDelay: Duration(20 * time.Millisecond)})
+
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&requests, 1)
+		switch r.URL.Path {
+		case "/flaky":
+			// 第一次要求等一个小时，等待时间被限制在MaxDelay
+			if n == 1 {
+				w.Header().Set("Retry-After", "3600")
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			fmt.Fprint(w, "ok")
+		case "/down":
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		path     string
+		requests int32
+		status   int
+	}{
+		{"/flaky", 2, 0},
+		{"/down", 3, http.StatusBadGateway}, // 最多尝试MaxAttempts次
+		{"/missing", 1, http.StatusNotFound},
+	}
+	for _, test := range tests {
+		atomic.StoreInt32(&requests, 0)
+		start := time.Now()
+		var body []byte
+		err := fetch(server.URL+test.path, func(res *http.Response) error {
+			var err error
+			body, err = io.ReadAll(res.Body)
+			return err
+		})
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("fetch %s took %v", test.path, elapsed)
+		}
+		if requests != test.requests {
+			t.Errorf("fetch %s sent %d requests, want %d", test.path, requests, test.requests)
+		}
+		var statusErr *statusError
+		switch {
+		case test.status == 0 && (err != nil || string(body) != "ok"):
+			t.Errorf("fetch %s = %q, %v, want ok", test.path, body, err)
+		case test.status != 0 && (!errors.As(err, &statusErr) || statusErr.statusCode != test.status):
+			t.Errorf("fetch %s error = %v, want status %d", test.path, err, test.status)
+		}
+	}
+}
+
+func TestFailedTasks(t *testing.T) {
+	useRetry(t, RetryConfig{MaxAttempts: 1})
+	conf.FailedFile = filepath.Join(t.TempDir(), "failed.txt")
+
+	recordFailure(task{Kind: taskAlbumList, URL: "http://www.kongjie.com/list?page=3"}, errors.New("GET: Bad Gateway"))
+	recordFailure(task{Kind: taskImagePage, URL: "http://www.kongjie.com/pic?id=1"}, errors.New("two\nlines"))
+	f, err := os.OpenFile(conf.FailedFile, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprint(f, "\nbroken line\nvideo\thttp://x\terr\n")
+	f.Close()
+
+	// 已经爬完的任务重新放入，还在队列中的任务不会重复放入，格式不对的行跳过
+	fr := newFrontier()
+	fr.Push(task{Kind: taskImagePage, URL: "http://www.kongjie.com/pic?id=1"})
+	fr.Push(task{Kind: taskAlbumList, URL: "http://www.kongjie.com/list?page=3"})
+	first, _ := fr.Next()
+	fr.Done(first)
+	size, err := requeueFailures(conf.FailedFile, fr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []task
+	for {
+		next, ok := fr.Next()
+		if !ok {
+			break
+		}
+		got = append(got, next)
+		fr.Done(next)
+	}
+	want := []task{
+		{Kind: taskImagePage, URL: "http://www.kongjie.com/pic?id=1"},
+		{Kind: taskAlbumList, URL: "http://www.kongjie.com/list?page=3"},
+	}
+	if first.URL != "http://www.kongjie.com/pic?id=1" || !reflect.DeepEqual(got, want) {
+		t.Errorf("requeued tasks = %+v after %+v, want %+v", got, first, want)
+	}
+
+	// 重新放入时不修改文件，dropFailures只删除重新放入的记录，保留这一次失败的任务
+	recordFailure(task{Kind: taskImagePage, URL: "http://www.kongjie.com/pic?id=2"}, errors.New("timeout"))
+	if err := dropFailures(conf.FailedFile, size); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(conf.FailedFile); err != nil || string(data) != "image\thttp://www.kongjie.com/pic?id=2\ttimeout\n" {
+		t.Errorf("failed file after drop = %q, %v, want only the new failure", data, err)
+	}
+
+	// 没有失败任务文件时没有要重新爬取的任务
+	if size, err := requeueFailures(filepath.Join(t.TempDir(), "missing.txt"), newFrontier()); size != 0 || err != nil {
+		t.Errorf("requeueFailures of a missing file = %d, %v, want 0, nil", size, err)
+	}
+}
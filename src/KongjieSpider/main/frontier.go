@@ -0,0 +1,185 @@
+package main
+
+import "sync"
+
+// 爬取的边界（frontier）：所有待爬取的url都放在这里，由conf.Concurrency个goroutine取出来爬取，
+// 爬取过程中发现的新url再放回来。队列没有长度限制，放入时不会阻塞；
+// 队列为空并且没有正在爬取的url时，说明所有url都爬取完了，Next返回false。
+
+// 任务类型，数值小的优先爬取：先把已经打开的相册爬完，再翻下一页相册列表，避免队列无限增长
+type taskKind int
+
+const (
+	taskImagePage taskKind = iota // 图片浏览页面，保存图片并继续爬下一张
+	taskAlbumList                 // 相册列表页，解析出所有用户的相册和下一页
+	numTaskKinds
+)
+
+func (k taskKind) String() string {
+	switch k {
+	case taskImagePage:
+		return "image"
+	case taskAlbumList:
+		return "album"
+	}
+	return "unknown"
+}
+
+// String的逆操作
+func parseTaskKind(s string) (taskKind, bool) {
+	for k := taskKind(0); k < numTaskKinds; k++ {
+		if k.String() == s {
+			return k, true
+		}
+	}
+	return 0, false
+}
+
+type task struct {
+	Kind taskKind `json:"kind"`
+	URL  string   `json:"url"`
+	Seen *bool    `json:"seen,omitempty"` // 图片页的图片是否爬取过，列表页批量查询后填入，nil表示还不知道
+}
+
+type frontier struct {
+	lock     sync.Mutex
+	cond     *sync.Cond
+	queues   [numTaskKinds][]task
+	queued   map[string]bool // 入过队的url，同一个url只爬取一次，避免相册的最后一张又链接回第一张时死循环
+	inFlight map[string]task // 已经取出但还没有Done的任务
+	closed   bool
+}
+
+func newFrontier() *frontier {
+	f := &frontier{queued: make(map[string]bool), inFlight: make(map[string]task)}
+	f.cond = sync.NewCond(&f.lock)
+	return f
+}
+
+// 放入任务，url已经入过队时返回false。
+// frontier关闭后仍然可以放入，正在爬取的页面发现的新url会保存到checkpoint中，-resume时继续爬取。
+func (f *frontier) Push(t task) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.queued[t.URL] {
+		return false
+	}
+	f.queued[t.URL] = true
+	f.queues[t.Kind] = append(f.queues[t.Kind], t)
+	f.cond.Signal()
+	return true
+}
+
+// 重新放入已经入过队的任务，用于重新爬取失败的任务。任务正在爬取或者还在队列中时返回false
+func (f *frontier) Requeue(t task) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if _, ok := f.inFlight[t.URL]; ok {
+		return false
+	}
+	for _, queued := range f.queues[t.Kind] {
+		if queued.URL == t.URL {
+			return false
+		}
+	}
+	f.queued[t.URL] = true
+	f.queues[t.Kind] = append(f.queues[t.Kind], t)
+	f.cond.Signal()
+	return true
+}
+
+// 取出一个任务，没有任务时阻塞等待。
+// 所有任务都完成了（队列为空并且没有正在爬取的任务）或者frontier被关闭时返回false。
+// 取出的任务处理完后必须调用Done。
+func (f *frontier) Next() (task, bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for {
+		if f.closed {
+			return task{}, false
+		}
+		for kind := range f.queues {
+			if queue := f.queues[kind]; len(queue) > 0 {
+				t := queue[0]
+				queue[0] = task{}
+				f.queues[kind] = queue[1:]
+				f.inFlight[t.URL] = t
+				return t, true
+			}
+		}
+		if len(f.inFlight) == 0 {
+			return task{}, false
+		}
+		// 还有任务在爬取，它们可能会放入新的任务
+		f.cond.Wait()
+	}
+}
+
+// 标记通过Next取出的任务已经处理完
+func (f *frontier) Done(t task) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	delete(f.inFlight, t.URL)
+	if len(f.inFlight) == 0 {
+		// 可能所有任务都完成了，唤醒所有等待的goroutine检查一下
+		f.cond.Broadcast()
+	}
+}
+
+// 关闭frontier，Next不再返回任务，正在处理的任务不受影响，它们放入的任务留在队列中
+func (f *frontier) Close() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.closed = true
+	f.cond.Broadcast()
+}
+
+// frontier中需要保存下来的状态，恢复后可以从保存时的位置继续爬取
+type frontierState struct {
+	Pending []task   `json:"pending"` // 待爬取的任务，包括保存时正在爬取的任务
+	Queued  []string `json:"queued"`  // 入过队的url，恢复后不会再次爬取已经完成的url
+}
+
+func (f *frontier) State() frontierState {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	var state frontierState
+	// 正在爬取的任务还没完成，恢复后要重新爬取，放在最前面
+	for _, t := range f.inFlight {
+		state.Pending = append(state.Pending, t)
+	}
+	for _, queue := range f.queues {
+		state.Pending = append(state.Pending, queue...)
+	}
+	state.Queued = make([]string, 0, len(f.queued))
+	for url := range f.queued {
+		state.Queued = append(state.Queued, url)
+	}
+	return state
+}
+
+// 恢复State返回的状态，只能在开始爬取之前调用
+func (f *frontier) Restore(state frontierState) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for _, url := range state.Queued {
+		f.queued[url] = true
+	}
+	for _, t := range state.Pending {
+		if t.Kind < 0 || t.Kind >= numTaskKinds {
+			continue
+		}
+		f.queued[t.URL] = true
+		f.queues[t.Kind] = append(f.queues[t.Kind], t)
+	}
+}
+
+// 待爬取的任务数和正在爬取的任务数
+func (f *frontier) Len() (pending, inFlight int) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for _, queue := range f.queues {
+		pending += len(queue)
+	}
+	return pending, len(f.inFlight)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 中断后正在爬取的页面放入的新任务要保存到checkpoint中，恢复后继续爬取，已经完成的url不再爬取
+func TestFrontierCloseKeepsPushes(t *testing.T) {
+	f := newFrontier()
+	f.Push(task{Kind: taskAlbumList, URL: "list1"})
+	f.Push(task{Kind: taskAlbumList, URL: "list2"})
+	list1, _ := f.Next()
+
+	f.Close()
+	if !f.Push(task{Kind: taskImagePage, URL: "image1"}) || !f.Push(task{Kind: taskAlbumList, URL: "list3"}) {
+		t.Fatal("Push after Close dropped the task")
+	}
+	f.Done(list1)
+	if t2, ok := f.Next(); ok {
+		t.Fatalf("Next after Close returned %v", t2)
+	}
+	if pending, inFlight := f.Len(); pending != 3 || inFlight != 0 {
+		t.Fatalf("Len = %d pending, %d in flight, want 3 and 0", pending, inFlight)
+	}
+
+	path := filepath.Join(t.TempDir(), "checkpoint.json")
+	if err := saveCheckpoint(path, f); err != nil {
+		t.Fatal(err)
+	}
+	state, err := loadCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(state.Queued)
+	if want := []string{"image1", "list1", "list2", "list3"}; !reflect.DeepEqual(state.Queued, want) {
+		t.Fatalf("queued = %v, want %v", state.Queued, want)
+	}
+
+	resumed := newFrontier()
+	resumed.Restore(state)
+	if resumed.Push(task{Kind: taskAlbumList, URL: "list1"}) {
+		t.Fatal("url finished before the checkpoint was queued again")
+	}
+	var got []task
+	for {
+		next, ok := resumed.Next()
+		if !ok {
+			break
+		}
+		got = append(got, next)
+		resumed.Done(next)
+	}
+	// 图片页优先
+	want := []task{{Kind: taskImagePage, URL: "image1"}, {Kind: taskAlbumList, URL: "list2"}, {Kind: taskAlbumList, URL: "list3"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("resumed tasks = %v, want %v", got, want)
+	}
+}
+
+// 保存时正在爬取的任务在恢复后要重新爬取
+func TestCheckpointKeepsInFlight(t *testing.T) {
+	f := newFrontier()
+	f.Push(task{Kind: taskImagePage, URL: "image1"})
+	f.Next()
+
+	path := filepath.Join(t.TempDir(), "checkpoint.json")
+	if err := saveCheckpoint(path, f); err != nil {
+		t.Fatal(err)
+	}
+	state, err := loadCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []task{{Kind: taskImagePage, URL: "image1"}}; !reflect.DeepEqual(state.Pending, want) {
+		t.Fatalf("pending = %v, want %v", state.Pending, want)
+	}
+}
+
+// 多个worker同时取任务，并在处理任务时放入新的任务，和crawl一样。
+// 只有所有任务都完成、不会再有新任务时Next才返回false，每个任务只执行一次，所有worker都退出
+func TestFrontierWorkers(t *testing.T) {
+	const (
+		lists     = 10 // 相册列表页的个数，每一页放入下一页
+		albums    = 5  // 每个列表页上的相册个数
+		pictures  = 4  // 每个相册的图片个数，每张图片页放入下一张
+		workers   = 8
+		wantTasks = lists + lists*albums*pictures
+	)
+	f := newFrontier()
+	f.Push(task{Kind: taskAlbumList, URL: "list/0"})
+
+	var lock sync.Mutex
+	runs := make(map[string]int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				next, ok := f.Next()
+				if !ok {
+					return
+				}
+				lock.Lock()
+				runs[next.URL]++
+				lock.Unlock()
+				// 让其他worker在这个任务放入新任务之前看到空队列
+				time.Sleep(time.Millisecond)
+
+				var list, album, picture int
+				switch {
+				case next.Kind == taskAlbumList:
+					fmt.Sscanf(next.URL, "list/%d", &list)
+					for a := 0; a < albums; a++ {
+						f.Push(task{Kind: taskImagePage, URL: fmt.Sprintf("pic/%d/%d/0", list, a)})
+					}
+					if list+1 < lists {
+						f.Push(task{Kind: taskAlbumList, URL: fmt.Sprintf("list/%d", list+1)})
+					}
+					// 已经入过队的url不会再执行
+					f.Push(task{Kind: taskAlbumList, URL: "list/0"})
+				default:
+					fmt.Sscanf(next.URL, "pic/%d/%d/%d", &list, &album, &picture)
+					if picture+1 < pictures {
+						f.Push(task{Kind: taskImagePage, URL: fmt.Sprintf("pic/%d/%d/%d", list, album, picture+1)})
+					}
+				}
+				f.Done(next)
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("workers did not exit after all tasks were done")
+	}
+
+	if len(runs) != wantTasks {
+		t.Errorf("%d distinct tasks ran, want %d", len(runs), wantTasks)
+	}
+	for url, n := range runs {
+		if n != 1 {
+			t.Errorf("%s ran %d times, want once", url, n)
+		}
+	}
+	if pending, inFlight := f.Len(); pending != 0 || inFlight != 0 {
+		t.Errorf("Len = %d pending, %d in flight after all workers exited, want 0 and 0", pending, inFlight)
+	}
+}
@@ -4,257 +4,355 @@ import (
 	"bufio"
 	"bytes"
 	"compress/gzip"
+	"errors"
+	"flag"
 	"fmt"
-	"github.com/gomodule/redigo/redis"
 	"golang.org/x/net/html/charset"
 	"golang.org/x/text/transform"
 	"io"
-	"io/ioutil"
+	"io/fs"
+	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"path"
-	"regexp"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
+	"time"
 )
 
-const (
-	ConcurrentNum = 20                         // 将会开启ConcurrentNum个goroutine来爬取用户相册中所有图片
-	SaveFolder    = `E:/Downloads/kongjiewang` // 图片保存的文件夹
-)
-
-var startUrl = `http://www.kongjie.com/home.php?mod=space&do=album&view=all&order=hot&page=1`
-var headers = map[string][]string{
-	"Accept":                    []string{"text/html,application/xhtml+xml,application/xml", "q=0.9,image/webp,*/*;q=0.8"},
-	"Accept-Encoding":           []string{"gzip, deflate, sdch"},
-	"Accept-Language":           []string{"zh-CN,zh;q=0.8,en;q=0.6,zh-TW;q=0.4"},
-	"Accept-Charset":            []string{"utf-8"},
-	"Connection":                []string{"keep-alive"},
-	"DNT":                       []string{"1"},
-	"Host":                      []string{"www.kongjie.com"},
-	"Referer":                   []string{"http://www.kongjie.com/home.php?mod=space&do=album&view=all&order=hot&page=1"},
-	"Upgrade-Insecure-Requests": []string{"1"},
-	"User-Agent":                []string{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/58.0.3029.110 Safari/537.36"},
-}
-
-// 用户id和图片id的正则表达式，用于从url中提取用户id和图片id，保存图片时这些id会拼接成图片名
-var uidPicIdPattern = regexp.MustCompile(`.*?uid=(\d+).*?picid=(\d+).*?`)
-
-// 图片链接的正则表达式，用于从图片浏览页面的html内容中提取出图片链接，然后保存图片
-var imageUrlPattern = regexp.MustCompile(`<div\s+?id="photo_pic"\s+?class="c">(?s:.*?)<a\s+?href=".*?<img\s+?src="(.*?)"\s+?id="pic"`)
+// 配置，在main中从命令行参数、环境变量和配置文件加载
+var conf = defaultConfig()
 
-// 下一张图片所在的图片浏览页面的链接正则表达式，用于从图片浏览页面提取出下一页链接，翻页爬取
-var nextImagePageUrlPattern = regexp.MustCompile(`<div\s+?class="pns\s+?mlnv\s+?vm\s+?mtm\s+?cl">(?s:.*?)<a\s+?href="(.*?)"\s+?class="btn".*?title="下一张"(?s:.*?)<img\s+?src".*?"\s+?alt="下一张"`)
+// 记录已经爬取过的图片，在main中按配置创建
+var seenStore SeenStore
 
-// 用户相册块的正则表达式，用于从相册列表页提取出用户相册块，用户相册块中包含很多个用户的相册链接
-var peopleUlPattern = regexp.MustCompile(`<div\s+?class="ptw">(?s:.*?)<ul\s+?class="ml\s+?mlp\s+?cl">(?s:(.*?))</ul>`)
+// 所有待爬取的相册列表页和图片浏览页面
+var crawlFrontier = newFrontier()
 
-// 用户相册的正则表达式，用于从用户相册块提取出用户相册链接，然后就可以进入相册爬取图片了
-var peopleItemPattern = regexp.MustCompile(`<li\s+?class="d">(?s:.*?)<div\s+?class="c">(?s:.*?)<a\s+?href="(.*?)">`)
-
-// 下一个相册列表页链接的正则表达式，用于从相册列表页提取出下一页链接，翻页爬取
-var nextAlbumPageUrlPattern = regexp.MustCompile(`<div\s+?class="pgs\s+?cl\s+?mtm">(?s:.*?)</label>(?s:.*?)<a\s+?href="(.*?)"\s+?class="nxt">下一页</a>`)
-
-// redis链接信息
-var redisOption = redis.DialPassword("flyvar")                      // redis密码
-var redisConn, _ = redis.Dial("tcp", "127.0.0.1:6379", redisOption) // 连接本地redis
-
-// 图片页面通道。每个相册点进去将会进入图片浏览页面，该通道就是为了存放这些图片浏览页面的url，供图片爬取的goroutine使用
-var imagePageUrlChan = make(chan string, 200)
+func main() {
+	var err error
+	conf, err = loadConfig(os.Args[1:])
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if conf.printConfig {
+		if err := conf.print(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
-var wg sync.WaitGroup
+	// 创建保存的文件夹
+	if err := os.MkdirAll(conf.SaveFolder, 0755); err != nil {
+		log.Fatal(err)
+	}
 
-// 串行访问redis，否则goroutine并发访问redis时会报错
-var redisLock sync.Mutex
+	httpClient = &http.Client{Timeout: time.Duration(conf.Timeout)}
+	polite = newPoliteness(conf.Politeness, httpClient, conf.Headers)
 
-func main() {
-	// 创建保存的文件夹
-	_, err := os.Open(SaveFolder)
+	seenStore, err = newSeenStore(conf)
 	if err != nil {
-		if os.IsNotExist(err) {
-			_ = os.MkdirAll(SaveFolder, 0666)
+		log.Fatal(err)
+	}
+	defer seenStore.Close()
+
+	// 从上一次的checkpoint继续，或者从第一个相册列表页开始爬取。
+	// 只重新爬取失败的任务时不从第一页开始
+	if conf.resume {
+		state, err := loadCheckpoint(conf.Checkpoint.Path)
+		if err != nil {
+			log.Fatal("can not resume: ", err)
 		}
+		crawlFrontier.Restore(state)
+		log.Printf("resumed from %s, %d pending, %d queued", conf.Checkpoint.Path, len(state.Pending), len(state.Queued))
+	} else if !conf.retryFailed {
+		crawlFrontier.Push(task{Kind: taskAlbumList, URL: conf.StartURL})
+	}
+	// 重新放入的任务在第一次保存checkpoint或者爬取结束之后才从失败任务文件中删除，
+	// 在这之前进程被杀掉，下一次还能用-retry-failed重新爬取
+	var retried int64
+	if conf.retryFailed {
+		if retried, err = requeueFailures(conf.FailedFile, crawlFrontier); err != nil {
+			log.Fatal("can not retry failed tasks: ", err)
+		}
+	}
+	var dropOnce sync.Once
+	dropRetried := func() {
+		if retried == 0 {
+			return
+		}
+		dropOnce.Do(func() {
+			if err := dropFailures(conf.FailedFile, retried); err != nil {
+				log.Println("can not remove retried tasks from failed file!", err)
+			}
+		})
 	}
 
-	// 开启CONCURRENT_NUM个goroutine来爬取用户相册中所有图片的动作
-	wg.Add(ConcurrentNum)
-	for i := 0; i < ConcurrentNum; i++ {
-		go getImagesInAlbum()
+	// 收到中断信号时不再取新的任务，等正在爬取的任务完成后保存checkpoint再退出
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(interrupted, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-interrupted
+		log.Println(sig, "received, waiting for in-flight pages, interrupt again to exit immediately")
+		crawlFrontier.Close()
+		<-interrupted
+		os.Exit(1)
+	}()
+	stopCheckpoints := make(chan struct{})
+	if conf.Checkpoint.Interval > 0 {
+		go runCheckpoints(conf.Checkpoint.Path, time.Duration(conf.Checkpoint.Interval), crawlFrontier, stopCheckpoints, dropRetried)
 	}
 
-	// 开启单个goroutine爬取所有用户的相册链接
-	parseAlbumUrl(startUrl)
+	// 开启conf.Concurrency个goroutine爬取
+	var wg sync.WaitGroup
+	wg.Add(conf.Concurrency)
+	for i := 0; i < conf.Concurrency; i++ {
+		go func() {
+			defer wg.Done()
+			crawl(crawlFrontier)
+		}()
+	}
 
-	// 等待爬取完成
+	// 等待爬取完成或者被中断
 	wg.Wait()
+	close(stopCheckpoints)
+	if pending, _ := crawlFrontier.Len(); pending > 0 {
+		if err := saveCheckpoint(conf.Checkpoint.Path, crawlFrontier); err != nil {
+			log.Fatal("can not save checkpoint! ", err)
+		}
+		dropRetried()
+		fmt.Printf("crawl stopped with %d pages pending, run with -resume to continue\n", pending)
+		return
+	}
+	dropRetried()
+	// 全部爬完了，checkpoint已经没用了
+	if err := os.Remove(conf.Checkpoint.Path); err != nil && !os.IsNotExist(err) {
+		log.Println(err)
+	}
+	fmt.Println("all albums crawled!")
 }
 
-// 解析出相册url，然后进入相册爬取图片
-func parseAlbumUrl(nextUrl string) {
+// 不断从frontier中取出任务爬取，直到所有任务都完成
+func crawl(f *frontier) {
 	for {
-		albumHtmlContent := getHtmlFromUrl(nextUrl)
-
-		// FindSubmatch查找正则表达式的匹配和所有的子匹配组，这里是查找当前页每个人的相册链接
-		peopleListElement := peopleUlPattern.FindSubmatch(albumHtmlContent)
-		if len(peopleListElement) <= 0 {
-			// 当前页没有相册
-			fmt.Println("no peopleListElement!, url=", nextUrl)
-			// 当前页所有用户相册链接解析完毕，翻到下一页
-			nextAlbumUrl := nextAlbumPageUrlPattern.FindSubmatch(albumHtmlContent)
-			if len(nextAlbumUrl) <= 0 {
-				fmt.Println("all albums crawled!")
-				break
-			}
-			nextUrl = string(nextAlbumUrl[1])
-			continue
+		t, ok := f.Next()
+		if !ok {
+			return
 		}
-
-		// 子匹配组是第二个元素。里面包含了很多用户的相册连接
-		peopleUlContent := peopleListElement[1]
-		peopleItems := peopleItemPattern.FindAllSubmatch(peopleUlContent, -1)
-		if len(peopleItems) > 0 {
-			for _, peopleItem := range peopleItems {
-				if len(peopleItem) <= 0 {
-					continue
-				}
-				// 找到了一个用户的相册链接，放入imagePageUrlChan中等待爬取
-				peopleAlbumUrl := strings.ReplaceAll(string(peopleItem[1]), `&amp;`, "&")
-				imagePageUrlChan <- peopleAlbumUrl
-			}
+		var err error
+		switch t.Kind {
+		case taskAlbumList:
+			err = parseAlbumList(conf.site, f, t.URL)
+		case taskImagePage:
+			err = getImageInPage(conf.site, f, t.URL, t.Seen)
 		}
-		// 当前页所有用户相册链接解析完毕，翻到下一页
-		nextAlbumUrl := nextAlbumPageUrlPattern.FindSubmatch(albumHtmlContent)
-		if len(nextAlbumUrl) <= 0 {
-			fmt.Println("all albums crawled!")
-			break
+		if err != nil {
+			fmt.Println("can not crawl "+t.Kind.String()+"!", err)
+			// robots.txt不允许的页面重试也没用
+			if !errors.Is(err, errDisallowed) {
+				recordFailure(t, err)
+			}
 		}
-		nextUrl = strings.ReplaceAll(string(nextAlbumUrl[1]), `&amp;`, "&")
-		fmt.Println(nextUrl)
+		f.Done(t)
 	}
-	close(imagePageUrlChan)
 }
 
-// 进入空姐网用户的相册，开始一张一张的保存相册中的图片。
-// 解析出uid和picId，用于存储图片的名字
-func getImagesInAlbum() {
-	for imagePageUrl := range imagePageUrlChan {
-		// 从当前图片页面url中获取当前图片所属的用户id和图片id
-		uidPicIdMatch := uidPicIdPattern.FindStringSubmatch(imagePageUrl)
-		if len(uidPicIdMatch) <= 0 {
-			fmt.Println("can not find any uidPicId! imagePageUrl=", imagePageUrl)
-			continue
+// 把path中记录的失败任务重新放入frontier，返回读取的字节数。path不存在时没有要重新爬取的任务。
+// 这一次仍然失败的任务会追加到path中
+func requeueFailures(path string, f *frontier) (int64, error) {
+	tasks, size, err := loadFailures(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		log.Printf("no failed tasks to retry, %s does not exist", path)
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	requeued := 0
+	for _, t := range tasks {
+		if f.Requeue(t) {
+			requeued++
 		}
-		uid := uidPicIdMatch[1]   // 用户id
-		picId := uidPicIdMatch[2] // 图片id
+	}
+	log.Printf("retrying %d failed tasks from %s", requeued, path)
+	return size, nil
+}
 
-		imagePageHtmlContent := getHtmlFromUrl(imagePageUrl)
+// 解析出相册列表页中所有用户的相册url和下一页相册列表的url，放入frontier等待爬取
+func parseAlbumList(site *Site, f *frontier, albumListUrl string) error {
+	albumHtmlContent, err := getHtmlFromUrl(albumListUrl)
+	if err != nil {
+		// 已经重试过了
+		return err
+	}
+	page, err := site.extractListPage(albumListUrl, albumHtmlContent)
+	if err != nil {
+		return fmt.Errorf("parse %s: %w", albumListUrl, err)
+	}
 
-		// redis中不存在，说明这张图片没被爬取过
-		exists := hexists("kongjie", uid+":"+picId)
-		if !exists {
-			// 获取图片src，即图片具体链接
-			imageSrcList := imageUrlPattern.FindSubmatch(imagePageHtmlContent)
-			if len(imageSrcList) > 0 {
-				imageSrc := string(imageSrcList[1])
-				imageSrc = strings.ReplaceAll(string(imageSrc), `&amp;`, "&")
-				saveImage(imageSrc, uid, picId)
-				hset("kongjie", uid+":"+picId, "1")
-			}
-		}
+	if len(page.Items) == 0 {
+		// 当前页没有相册
+		fmt.Println("no albums!, url=", albumListUrl)
+	}
+	// 一次批量查询这一页所有相册的第一张图片是否爬取过，爬取图片页时不用再逐个查询
+	seen := batchSeen(site, page.Items)
+	for i, album := range page.Items {
+		// 相册点进去就是第一张图片的浏览页面
+		f.Push(task{Kind: taskImagePage, URL: album, Seen: seen[i]})
+	}
 
-		// 解析下一张图片页面的url，继续爬取
-		nextImagePageUrlSubmatch := nextImagePageUrlPattern.FindSubmatch(imagePageHtmlContent)
-		if len(nextImagePageUrlSubmatch) <= 0 {
-			continue
-		}
-		nextImagePageUrl := string(nextImagePageUrlSubmatch[1])
-		imagePageUrlChan <- nextImagePageUrl
+	// 当前页所有用户相册链接解析完毕，翻到下一页
+	if page.Next == "" {
+		fmt.Println("last album page reached!", albumListUrl)
+		return nil
 	}
-	wg.Done()
+	fmt.Println(page.Next)
+	f.Push(task{Kind: taskAlbumList, URL: page.Next})
+	return nil
 }
 
-// 保存图片到全局变量saveFolder文件夹下，图片名字为“uid_picId.ext”。
-// 其中，uid是用户id，picId是空姐网图片id，ext是图片的扩展名。
-func saveImage(imageUrl string, uid string, picId string) {
-	res := getReponseWithGlobalHeaders(imageUrl)
-	defer func() {
-		if err := res.Body.Close(); err != nil {
-			fmt.Println(err)
+// 批量查询详情页中的图片是否爬取过，返回的切片和detailUrls一一对应，无法判断时为nil
+func batchSeen(site *Site, detailUrls []string) []*bool {
+	seen := make([]*bool, len(detailUrls))
+	var keys []string
+	var indexes []int
+	for i, detailUrl := range detailUrls {
+		if key, _, ok := site.imageID(detailUrl); ok {
+			keys = append(keys, key)
+			indexes = append(indexes, i)
 		}
-	}()
-	// 获取图片扩展名
-	fileNameExt := path.Ext(imageUrl)
-	// 图片保存的全路径
-	savePath := path.Join(SaveFolder, uid+"_"+picId+fileNameExt)
-	imageWriter, _ := os.OpenFile(savePath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
-	length, _ := io.Copy(imageWriter, res.Body)
-	fmt.Println(uid + "_" + picId + fileNameExt + " image saved! " + strconv.Itoa(int(length)) + " bytes." + imageUrl)
-}
-
-func hexists(key, field string) bool {
-	redisLock.Lock()
-	defer redisLock.Unlock()
-	exists, err := redisConn.Do("HEXISTS", key, field)
+	}
+	if len(keys) == 0 {
+		return seen
+	}
+	exists, err := seenStore.SeenBatch(keys)
 	if err != nil {
-		fmt.Println("redis hexists error!", err)
+		// 查询出错时留到爬取图片页时再逐个查询
+		fmt.Println("seen store error!", err)
+		return seen
 	}
-	if exists == nil {
-		return false
+	for j, i := range indexes {
+		seen[i] = &exists[j]
 	}
-	return exists.(int64) == 1
+	return seen
 }
 
-func hset(key, field, value string) bool {
-	redisLock.Lock()
-	defer redisLock.Unlock()
-	ok, err := redisConn.Do("HSET", key, field, value)
+// 保存用户相册中的一张图片，然后把下一张图片的浏览页面放入frontier。
+// 按站点定义从url中解析出图片的key和文件名，空姐网是“uid:picId”和“uid_picId”。
+// known不为nil时是列表页批量查询的结果，不用再查询seenStore。
+// 图片保存失败时仍然继续爬取下一张，返回的错误让这一页之后可以重新爬取
+func getImageInPage(site *Site, f *frontier, imagePageUrl string, known *bool) error {
+	key, fileName, ok := site.imageID(imagePageUrl)
+	if !ok {
+		// 重试也不会成功，不返回错误
+		fmt.Println("can not find image id! imagePageUrl=", imagePageUrl)
+		return nil
+	}
+
+	imagePageHtmlContent, err := getHtmlFromUrl(imagePageUrl)
 	if err != nil {
-		fmt.Println("redis hset error!", err)
+		return err
 	}
-	if ok == nil {
-		return false
+	page, err := site.extractDetailPage(imagePageUrl, imagePageHtmlContent)
+	if err != nil {
+		return fmt.Errorf("parse %s: %w", imagePageUrl, err)
 	}
-	return ok.(int64) == 1
-}
 
-func getReponseWithGlobalHeaders(url string) *http.Response {
-	req, _ := http.NewRequest("GET", url, nil)
-	if headers != nil && len(headers) != 0 {
-		for k, v := range headers {
-			for _, val := range v {
-				req.Header.Add(k, val)
-			}
+	// seenStore中不存在，说明这张图片没被爬取过。查询出错时当作没爬取过，最多重复下载一次
+	var exists bool
+	if known != nil {
+		exists = *known
+	} else if exists, err = seenStore.Seen(key); err != nil {
+		fmt.Println("seen store error!", err)
+	}
+	var saveErr error
+	if !exists && page.Image != "" {
+		// 保存失败的图片不记录到seenStore，下次还会再爬
+		if saveErr = saveImage(page.Image, fileName); saveErr != nil {
+			saveErr = fmt.Errorf("save image of %s: %w", imagePageUrl, saveErr)
+		} else if err := seenStore.MarkSeen(key); err != nil {
+			fmt.Println("seen store error!", err)
 		}
 	}
 
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		panic(err)
+	// 下一张图片页面，继续爬取
+	if page.Next != "" {
+		f.Push(task{Kind: taskImagePage, URL: page.Next})
 	}
-	return res
+	return saveErr
 }
 
-func getHtmlFromUrl(url string) []byte {
-	response := getReponseWithGlobalHeaders(url)
-
-	reader := response.Body
-	// 返回的内容被压缩成gzip格式了，需要解压一下
-	if response.Header.Get("Content-Encoding") == "gzip" {
-		reader, _ = gzip.NewReader(response.Body)
+// 保存图片到conf.SaveFolder文件夹下，图片名字为“fileName.ext”。
+// 其中，fileName按站点定义从详情页url中提取，空姐网是“uid_picId”，ext是图片的扩展名。
+// 图片先写入临时文件，下载完整后才重命名，重试或失败时不会留下不完整的图片。
+func saveImage(imageUrl string, fileName string) error {
+	// 获取图片扩展名，不包括url中?后面的参数
+	fileNameExt := path.Ext(imageUrl)
+	if u, err := url.Parse(imageUrl); err == nil {
+		fileNameExt = path.Ext(u.Path)
 	}
-	// 此时htmlContent还是gbk编码，需要转换成utf8编码
-	htmlContent, _ := ioutil.ReadAll(reader)
+	// 文件名来自url，不能让它跳出保存的文件夹
+	fileName = strings.NewReplacer("/", "_", "\\", "_").Replace(fileName)
+	// 图片保存的全路径
+	savePath := filepath.Join(conf.SaveFolder, fileName+fileNameExt)
+	return fetch(imageUrl, func(res *http.Response) error {
+		tmp, err := os.CreateTemp(conf.SaveFolder, fileName+".tmp*")
+		if err != nil {
+			return err
+		}
+		length, err := io.Copy(tmp, res.Body)
+		if closeErr := tmp.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = os.Rename(tmp.Name(), savePath)
+		}
+		if err != nil {
+			os.Remove(tmp.Name())
+			return err
+		}
+		fmt.Println(fileName + fileNameExt + " image saved! " + strconv.Itoa(int(length)) + " bytes." + imageUrl)
+		return nil
+	})
+}
 
-	oldReader := bufio.NewReader(bytes.NewReader(htmlContent))
+// 获取url对应的html内容，并转换成utf8编码
+func getHtmlFromUrl(url string) ([]byte, error) {
+	var htmlContent []byte
+	err := fetch(url, func(response *http.Response) error {
+		var reader io.Reader = response.Body
+		// 返回的内容被压缩成gzip格式了，需要解压一下
+		if response.Header.Get("Content-Encoding") == "gzip" {
+			gzipReader, err := gzip.NewReader(response.Body)
+			if err != nil {
+				return err
+			}
+			reader = gzipReader
+		}
+		// 此时content还是gbk编码，需要转换成utf8编码
+		content, err := io.ReadAll(reader)
+		if err != nil {
+			return err
+		}
+		htmlContent, err = toUTF8(content, response.Header.Get("Content-Type"))
+		return err
+	})
+	return htmlContent, err
+}
+
+// 根据Content-Type和html中的<meta charset>判断编码，转换成utf8编码
+func toUTF8(content []byte, contentType string) ([]byte, error) {
+	oldReader := bufio.NewReader(bytes.NewReader(content))
 	peekBytes, _ := oldReader.Peek(1024)
-	e, _, _ := charset.DetermineEncoding(peekBytes, "")
+	e, _, _ := charset.DetermineEncoding(peekBytes, contentType)
 	utf8reader := transform.NewReader(oldReader, e.NewDecoder())
-	// 此时htmlContent就已经是utf8编码了
-	htmlContent, _ = ioutil.ReadAll(utf8reader)
-
-	if err := response.Body.Close(); err != nil {
-		fmt.Println("error happened when closing response body!", err)
-	}
-	return htmlContent
+	return io.ReadAll(utf8reader)
 }
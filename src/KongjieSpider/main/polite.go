@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 礼貌地爬取：同一个host的请求用令牌桶限速，并限制同时进行的请求数；
+// 第一次请求某个host之前先读取它的robots.txt，不爬取被禁止的url，有Crawl-delay时按它放慢速度。
+// 按RFC 9309，robots.txt返回4xx时不做限制；返回5xx或者连不上时整个host都不爬取，过一段时间再重新获取。
+
+var (
+	// url被robots.txt禁止爬取
+	errDisallowed = errors.New("disallowed by robots.txt")
+	// robots.txt暂时获取不到，不知道是否允许爬取
+	errRobotsUnavailable = errors.New("robots.txt unavailable")
+)
+
+// robots.txt获取失败后，过这么久再重新获取
+const robotsRetryInterval = time.Minute
+
+type politeness struct {
+	conf        PolitenessConfig
+	client      *http.Client
+	userAgent   string        // 请求robots.txt时带的User-Agent
+	product     string        // 和robots.txt中的User-agent匹配的名字
+	robotsRetry time.Duration // robots.txt获取失败后重新获取的间隔
+
+	lock  sync.Mutex
+	hosts map[string]*hostState // key是scheme://host
+}
+
+// 一个host的限速状态和robots.txt规则
+type hostState struct {
+	bucket *tokenBucket
+	slots  chan struct{} // 容量为MaxInFlightPerHost的信号量，nil表示不限制
+
+	robotsLock    sync.Mutex
+	robotsFetched bool
+	robots        *robotsRules
+	robotsErr     error     // 上一次获取robots.txt失败的原因
+	robotsFailed  time.Time // 上一次获取robots.txt失败的时间
+}
+
+// headers是每个请求都会带上的header。
+// 用conf.UserAgent匹配robots.txt，为空时用headers中User-Agent的产品名，例如“kongjie-spider/1.0”中的“kongjie-spider”
+func newPoliteness(pc PolitenessConfig, client *http.Client, headers map[string][]string) *politeness {
+	p := &politeness{
+		conf:        pc,
+		client:      client,
+		userAgent:   http.Header(headers).Get("User-Agent"),
+		robotsRetry: robotsRetryInterval,
+		hosts:       make(map[string]*hostState),
+	}
+	if p.userAgent == "" {
+		p.userAgent = pc.UserAgent
+	}
+	if pc.UserAgent != "" {
+		p.product = productToken(pc.UserAgent)
+	} else {
+		p.product = productToken(p.userAgent)
+	}
+	return p
+}
+
+// User-Agent开头的产品名：RFC 9309规定只能包含字母、“_”和“-”
+func productToken(userAgent string) string {
+	userAgent = strings.TrimSpace(userAgent)
+	end := strings.IndexFunc(userAgent, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r == '_' || r == '-')
+	})
+	if end >= 0 {
+		userAgent = userAgent[:end]
+	}
+	return strings.ToLower(userAgent)
+}
+
+func (p *politeness) host(u *url.URL) *hostState {
+	key := u.Scheme + "://" + u.Host
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	h, ok := p.hosts[key]
+	if !ok {
+		h = &hostState{bucket: newTokenBucket(p.conf.RatePerHost, p.conf.BurstPerHost)}
+		if p.conf.MaxInFlightPerHost > 0 {
+			h.slots = make(chan struct{}, p.conf.MaxInFlightPerHost)
+		}
+		p.hosts[key] = h
+	}
+	return h
+}
+
+// 判断robots.txt是否允许爬取rawUrl，第一次请求某个host时会先下载它的robots.txt。
+// 不允许时返回errDisallowed，robots.txt获取不到时返回errRobotsUnavailable。
+// 没有开启robots或者url无法解析时返回nil，由之后的请求报错。
+func (p *politeness) check(rawUrl string) error {
+	if !p.conf.Robots {
+		return nil
+	}
+	u, err := url.Parse(rawUrl)
+	if err != nil || u.Host == "" {
+		return nil
+	}
+	h := p.host(u)
+	h.robotsLock.Lock()
+	if !h.robotsFetched || (h.robotsErr != nil && time.Since(h.robotsFailed) >= p.robotsRetry) {
+		h.robots, h.robotsErr = p.fetchRobots(h, u)
+		h.robotsFetched = true
+		if h.robotsErr != nil {
+			h.robotsFailed = time.Now()
+		} else if delay := h.robots.crawlDelay(); delay > 0 {
+			h.bucket.slowDown(float64(time.Second) / float64(delay))
+		}
+	}
+	robots, robotsErr := h.robots, h.robotsErr
+	h.robotsLock.Unlock()
+
+	if robotsErr != nil {
+		return robotsErr
+	}
+	if !robots.allowed(u) {
+		return errDisallowed
+	}
+	return nil
+}
+
+// 等到可以向u所在的host发请求时返回，请求处理完后必须调用返回的release
+func (p *politeness) wait(u *url.URL) (release func()) {
+	h := p.host(u)
+	if h.slots != nil {
+		h.slots <- struct{}{}
+	}
+	time.Sleep(h.bucket.reserve())
+	return func() {
+		if h.slots != nil {
+			<-h.slots
+		}
+	}
+}
+
+// robots.txt最多读取的大小，RFC 9309要求至少支持500KiB
+const maxRobotsSize = 500 << 10
+
+// 下载并解析robots.txt。返回4xx时返回nil，表示不做限制；返回5xx、连不上或者读取失败时返回errRobotsUnavailable
+func (p *politeness) fetchRobots(h *hostState, u *url.URL) (*robotsRules, error) {
+	robotsUrl := u.Scheme + "://" + u.Host + "/robots.txt"
+	req, err := http.NewRequest("GET", robotsUrl, nil)
+	if err != nil {
+		return nil, nil
+	}
+	// 只带User-Agent，其它header（例如Accept-Encoding）会让client不再自动解压
+	if p.userAgent != "" {
+		req.Header.Set("User-Agent", p.userAgent)
+	}
+	time.Sleep(h.bucket.reserve())
+	res, err := p.client.Do(req)
+	if err != nil {
+		log.Println("can not fetch robots.txt, do not crawl the host for now!", err)
+		return nil, fmt.Errorf("%w: %v", errRobotsUnavailable, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 400 && res.StatusCode <= 499 {
+		if res.StatusCode != http.StatusNotFound {
+			log.Printf("GET %s: %s, crawl without it", robotsUrl, res.Status)
+		}
+		return nil, nil
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		log.Printf("GET %s: %s, do not crawl the host for now", robotsUrl, res.Status)
+		return nil, fmt.Errorf("%w: GET %s: %s", errRobotsUnavailable, robotsUrl, res.Status)
+	}
+	content, err := io.ReadAll(io.LimitReader(res.Body, maxRobotsSize))
+	if err != nil {
+		log.Println("can not read robots.txt, do not crawl the host for now!", err)
+		return nil, fmt.Errorf("%w: %v", errRobotsUnavailable, err)
+	}
+	return parseRobots(content, p.product), nil
+}
+
+// 令牌桶：每秒放入rate个令牌，最多存burst个，每个请求取走一个
+type tokenBucket struct {
+	lock   sync.Mutex
+	rate   float64 // 0表示不限速
+	burst  float64
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	b := &tokenBucket{rate: rate, burst: math.Max(float64(burst), 1), now: time.Now}
+	b.tokens = b.burst
+	b.last = b.now()
+	return b
+}
+
+// 取走一个令牌，返回拿到令牌前需要等待的时间。
+// 令牌不够时预支，之后的请求排在它后面，所以并发调用时每个请求仍然间隔1/rate。
+func (b *tokenBucket) reserve() time.Duration {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.rate <= 0 {
+		return 0
+	}
+	now := b.now()
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// 把速度降到不超过rate，并且不再允许连续发出请求，用于robots.txt中的Crawl-delay
+func (b *tokenBucket) slowDown(rate float64) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.rate <= 0 || rate < b.rate {
+		b.rate = rate
+	}
+	b.burst = 1
+	b.tokens = math.Min(b.tokens, b.burst)
+}
+
+// robots.txt中对我们生效的规则
+type robotsRules struct {
+	rules []robotsRule
+	delay time.Duration
+}
+
+type robotsRule struct {
+	allow   bool
+	pattern string // 原始的路径规则，越长越优先
+	re      *regexp.Regexp
+}
+
+// nil表示没有限制
+func (r *robotsRules) allowed(u *url.URL) bool {
+	if r == nil {
+		return true
+	}
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+	// 最长的规则生效，一样长时Allow优先
+	allowed, matched := true, -1
+	for _, rule := range r.rules {
+		if len(rule.pattern) < matched || (len(rule.pattern) == matched && !rule.allow) || !rule.re.MatchString(path) {
+			continue
+		}
+		allowed, matched = rule.allow, len(rule.pattern)
+	}
+	return allowed
+}
+
+func (r *robotsRules) crawlDelay() time.Duration {
+	if r == nil {
+		return 0
+	}
+	return r.delay
+}
+
+// 解析robots.txt，返回对product生效的规则：
+// 选User-agent和product的产品名相同的那一组（不区分大小写），没有时用User-agent: *的那一组，
+// User-agent相同的多组规则合并在一起。
+func parseRobots(content []byte, product string) *robotsRules {
+	type group struct {
+		agents []string
+		rules  robotsRules
+	}
+	var groups []*group
+	var current *group
+	inRules := false // 当前组已经有规则了，再遇到User-agent时开始新的一组
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key, value = strings.ToLower(strings.TrimSpace(key)), strings.TrimSpace(value)
+		switch key {
+		case "user-agent":
+			if current == nil || inRules {
+				current = &group{}
+				groups = append(groups, current)
+				inRules = false
+			}
+			if value != "*" {
+				value = productToken(value)
+			}
+			current.agents = append(current.agents, value)
+		case "allow", "disallow":
+			if current == nil {
+				continue
+			}
+			inRules = true
+			// 空的Disallow表示不禁止任何url
+			if value != "" {
+				current.rules.rules = append(current.rules.rules, robotsRule{allow: key == "allow", pattern: value, re: compileRobotsPattern(value)})
+			}
+		case "crawl-delay":
+			if current == nil {
+				continue
+			}
+			inRules = true
+			if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds > 0 {
+				current.rules.delay = time.Duration(seconds * float64(time.Second))
+			}
+		}
+	}
+
+	product = productToken(product)
+	best := "*"
+	for _, g := range groups {
+		for _, agent := range g.agents {
+			if agent != "" && agent == product {
+				best = agent
+			}
+		}
+	}
+	rules := &robotsRules{}
+	for _, g := range groups {
+		for _, agent := range g.agents {
+			if agent == best {
+				rules.rules = append(rules.rules, g.rules.rules...)
+				if g.rules.delay > rules.delay {
+					rules.delay = g.rules.delay
+				}
+				break
+			}
+		}
+	}
+	return rules
+}
+
+// 把robots.txt中的路径规则转换成正则表达式：*匹配任意字符，结尾的$表示url到此为止，否则按前缀匹配
+func compileRobotsPattern(pattern string) *regexp.Regexp {
+	anchored := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+	expr := "^" + strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*")
+	if anchored {
+		expr += "$"
+	}
+	return regexp.MustCompile(expr)
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 按pc替换全局的polite，测试结束后恢复
+func usePoliteness(t *testing.T, pc PolitenessConfig, userAgent string) {
+	old := polite
+	polite = newPoliteness(pc, http.DefaultClient, map[string][]string{"User-Agent": {userAgent}})
+	t.Cleanup(func() { polite = old })
+}
+
+// 依次请求urls，可以在多个goroutine中调用
+func fetchAll(t *testing.T, urls ...string) {
+	t.Helper()
+	for _, u := range urls {
+		if err := fetch(u, func(res *http.Response) error { return nil }); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestParseRobots(t *testing.T) {
+	robots := []byte(`
+# 注释和空行会被忽略
+User-agent: *
+Disallow: /home.php?mod=spacecp
+Disallow: /*.zip$
+Allow: /home.php?mod=spacecp&ac=album
+Crawl-delay: 2
+
+User-agent: BadBot
+User-agent: OtherBot
+Disallow: /
+
+User-agent: kongjie-spider
+Disallow: /private
+Allow: /private/public
+Crawl-delay: 0.5
+
+user-agent: KongJie-Spider/2.0
+disallow: /tmp
+
+User-agent: kongjie
+Disallow: /
+`)
+	tests := []struct {
+		userAgent string
+		path      string
+		allowed   bool
+	}{
+		{"Mozilla/5.0", "/home.php?mod=space&do=album", true},
+		{"Mozilla/5.0", "/home.php?mod=spacecp&op=delete", false},
+		{"Mozilla/5.0", "/home.php?mod=spacecp&ac=album&op=edit", true}, // 更长的Allow优先
+		{"Mozilla/5.0", "/data/a.zip", false},
+		{"Mozilla/5.0", "/data/a.zip?v=1", true}, // $要求url到此为止
+		{"BadBot/1.0", "/anything", false},
+		{"Mozilla/5.0 (compatible; BadBot/1.0)", "/anything", true}, // 只看开头的产品名，不在整个User-Agent中查找
+		{"otherbot", "/", false},
+		{"kongjie-spider/1.0", "/home.php?mod=spacecp", true}, // 有匹配的组时不再使用*的规则
+		{"kongjie-spider/1.0", "/private/a.jpg", false},
+		{"kongjie-spider/1.0", "/private/public/a.jpg", true},
+		{"kongjie-spider/1.0", "/tmp/a.jpg", false}, // User-agent相同的组合并，不区分大小写
+		{"kongjie", "/private/a.jpg", false},        // kongjie-spider的组对kongjie不生效
+		{"kongjie", "/tmp/a.jpg", false},
+		{"kongjie-spider-next/1.0", "/home.php?mod=spacecp", false}, // 不是前缀匹配，没有匹配的组时使用*
+	}
+	for _, test := range tests {
+		u, err := url.Parse("http://www.kongjie.com" + test.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if allowed := parseRobots(robots, test.userAgent).allowed(u); allowed != test.allowed {
+			t.Errorf("%s allowed %s = %v, want %v", test.userAgent, test.path, allowed, test.allowed)
+		}
+	}
+	if delay := parseRobots(robots, "Mozilla/5.0").crawlDelay(); delay != 2*time.Second {
+		t.Errorf("crawl-delay for * = %v, want 2s", delay)
+	}
+	if delay := parseRobots(robots, "kongjie-spider").crawlDelay(); delay != 500*time.Millisecond {
+		t.Errorf("crawl-delay for kongjie-spider = %v, want 500ms", delay)
+	}
+}
+
+func TestProductToken(t *testing.T) {
+	tests := map[string]string{
+		"kongjie-spider/1.0":                   "kongjie-spider",
+		"Mozilla/5.0 (compatible; BadBot/1.0)": "mozilla",
+		" Googlebot ":                          "googlebot",
+		"my_bot":                               "my_bot",
+		"":                                     "",
+	}
+	for userAgent, want := range tests {
+		if got := productToken(userAgent); got != want {
+			t.Errorf("productToken(%q) = %q, want %q", userAgent, got, want)
+		}
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Unix(0, 0)
+	b := newTokenBucket(10, 2)
+	b.now = func() time.Time { return now }
+	b.last = now
+
+	// 开始时可以连续发出burst个请求，之后每个请求间隔1/rate
+	for i, want := range []time.Duration{0, 0, 100 * time.Millisecond, 200 * time.Millisecond} {
+		if got := b.reserve(); got != want {
+			t.Errorf("reserve #%d = %v, want %v", i, got, want)
+		}
+	}
+	// 等待期间预支的令牌补上之后，最多攒够burst个
+	now = now.Add(time.Second)
+	for i, want := range []time.Duration{0, 0, 100 * time.Millisecond} {
+		if got := b.reserve(); got != want {
+			t.Errorf("reserve after refill #%d = %v, want %v", i, got, want)
+		}
+	}
+
+	b.slowDown(2)
+	now = now.Add(time.Second)
+	for i, want := range []time.Duration{0, 500 * time.Millisecond} {
+		if got := b.reserve(); got != want {
+			t.Errorf("reserve after slowDown #%d = %v, want %v", i, got, want)
+		}
+	}
+
+	if got := newTokenBucket(0, 0).reserve(); got != 0 {
+		t.Errorf("unlimited bucket reserve = %v, want 0", got)
+	}
+}
+
+func TestRobotsDisallow(t *testing.T) {
+	var robotsRequests, pageRequests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			atomic.AddInt32(&robotsRequests, 1)
+			if ua := r.Header.Get("User-Agent"); ua != "kongjie-spider/1.0" {
+				t.Errorf("robots.txt requested with User-Agent %q", ua)
+			}
+			fmt.Fprint(w, "User-agent: *\nDisallow: /\n\nUser-agent: kongjie-spider\nDisallow: /private\n")
+			return
+		}
+		atomic.AddInt32(&pageRequests, 1)
+	}))
+	defer server.Close()
+	usePoliteness(t, PolitenessConfig{Robots: true}, "kongjie-spider/1.0")
+
+	fetchAll(t, server.URL+"/album?page=1", server.URL+"/album?page=2")
+	err := fetch(server.URL+"/private/a.jpg", func(res *http.Response) error { return nil })
+	if !errors.Is(err, errDisallowed) {
+		t.Fatalf("fetch disallowed url error = %v, want errDisallowed", err)
+	}
+	if robotsRequests != 1 || pageRequests != 2 {
+		t.Fatalf("server got %d robots.txt and %d page requests, want 1 and 2", robotsRequests, pageRequests)
+	}
+}
+
+// robots.txt返回4xx时不做限制
+func TestRobotsMissing(t *testing.T) {
+	for _, status := range []int{http.StatusNotFound, http.StatusForbidden, http.StatusGone} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/robots.txt" {
+				w.WriteHeader(status)
+			}
+		}))
+		usePoliteness(t, PolitenessConfig{Robots: true}, "kongjie-spider/1.0")
+		fetchAll(t, server.URL+"/private/a.jpg")
+		server.Close()
+	}
+}
+
+// robots.txt返回5xx或者连不上时整个host都不爬取，过一段时间后重新获取
+func TestRobotsUnavailable(t *testing.T) {
+	var robotsStatus, pageRequests int32
+	atomic.StoreInt32(&robotsStatus, http.StatusServiceUnavailable)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			w.WriteHeader(int(atomic.LoadInt32(&robotsStatus)))
+			return
+		}
+		atomic.AddInt32(&pageRequests, 1)
+	}))
+	defer server.Close()
+	usePoliteness(t, PolitenessConfig{Robots: true}, "kongjie-spider/1.0")
+
+	err := fetch(server.URL+"/album", func(res *http.Response) error { return nil })
+	if !errors.Is(err, errRobotsUnavailable) {
+		t.Fatalf("fetch with robots.txt 503 error = %v, want errRobotsUnavailable", err)
+	}
+	// 还没到重新获取的时间
+	atomic.StoreInt32(&robotsStatus, http.StatusNotFound)
+	if err := fetch(server.URL+"/album", func(res *http.Response) error { return nil }); !errors.Is(err, errRobotsUnavailable) {
+		t.Fatalf("fetch before the robots.txt retry error = %v, want errRobotsUnavailable", err)
+	}
+	if pageRequests != 0 {
+		t.Fatalf("server got %d page requests while robots.txt was unavailable", pageRequests)
+	}
+	polite.robotsRetry = 0
+	fetchAll(t, server.URL+"/private/a.jpg")
+	if err := fetch(server.URL+"/private/b.jpg", func(res *http.Response) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	// 连不上的host
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	if err := fetch(down.URL+"/album", func(res *http.Response) error { return nil }); !errors.Is(err, errRobotsUnavailable) {
+		t.Fatalf("fetch from unreachable host error = %v, want errRobotsUnavailable", err)
+	}
+}
+
+func TestCrawlDelay(t *testing.T) {
+	var lock sync.Mutex
+	var times []time.Time
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			fmt.Fprint(w, "User-agent: *\nCrawl-delay: 0.05\n")
+			return
+		}
+		lock.Lock()
+		times = append(times, time.Now())
+		lock.Unlock()
+	}))
+	defer server.Close()
+	// 配置的速度比Crawl-delay快，按Crawl-delay限速，并且不能连续发出请求
+	usePoliteness(t, PolitenessConfig{RatePerHost: 1000, BurstPerHost: 10, Robots: true}, "kongjie-spider/1.0")
+
+	fetchAll(t, server.URL+"/1", server.URL+"/2", server.URL+"/3")
+	for i := 1; i < len(times); i++ {
+		// 允许一点计时误差
+		if gap := times[i].Sub(times[i-1]); gap < 45*time.Millisecond {
+			t.Errorf("request %d sent %v after the previous one, want at least the 50ms crawl-delay", i, gap)
+		}
+	}
+}
+
+func TestRatePerHost(t *testing.T) {
+	var requests int32
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { atomic.AddInt32(&requests, 1) })
+	slow, fast := httptest.NewServer(handler), httptest.NewServer(handler)
+	defer slow.Close()
+	defer fast.Close()
+	usePoliteness(t, PolitenessConfig{RatePerHost: 20, BurstPerHost: 2}, "")
+
+	// 另一个host有自己的令牌桶，不会被拖慢
+	start := time.Now()
+	fetchAll(t, slow.URL+"/1", slow.URL+"/2", fast.URL+"/1", fast.URL+"/2")
+	if elapsed := time.Since(start); elapsed > 45*time.Millisecond {
+		t.Errorf("burst of 2 requests per host took %v, want no waiting", elapsed)
+	}
+
+	// 之后的请求间隔1/20秒，4个请求至少200ms
+	start = time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			fetchAll(t, fmt.Sprintf("%s/page/%d", slow.URL, i))
+		}(i)
+	}
+	wg.Wait()
+	if elapsed := time.Since(start); elapsed < 180*time.Millisecond {
+		t.Errorf("4 requests at 20/s took %v, want at least 200ms", elapsed)
+	}
+	if requests != 8 {
+		t.Errorf("server got %d requests, want 8", requests)
+	}
+}
+
+func TestMaxInFlightPerHost(t *testing.T) {
+	var inFlight, maxInFlight int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			seen := atomic.LoadInt32(&maxInFlight)
+			if n <= seen || atomic.CompareAndSwapInt32(&maxInFlight, seen, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+	}))
+	defer server.Close()
+	usePoliteness(t, PolitenessConfig{MaxInFlightPerHost: 2}, "")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			fetchAll(t, fmt.Sprintf("%s/%d", server.URL, i))
+		}(i)
+	}
+	wg.Wait()
+	if maxInFlight != 2 {
+		t.Fatalf("server saw at most %d concurrent requests, want 2", maxInFlight)
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	cache "rv/LRUCache"
+
+	"github.com/gomodule/redigo/redis"
+	bolt "go.etcd.io/bbolt"
+)
+
+// 记录哪些图片已经爬取过，避免重复下载。key是“uid:picId”。
+type SeenStore interface {
+	// key是否已经爬取过
+	Seen(key string) (bool, error)
+	// 一次判断多个key是否已经爬取过，返回的结果和keys一一对应
+	SeenBatch(keys []string) ([]bool, error)
+	// 记录key已经爬取过
+	MarkSeen(key string) error
+	Close() error
+}
+
+// 按conf.Seen.Store创建SeenStore
+func newSeenStore(conf *Config) (SeenStore, error) {
+	switch conf.Seen.Store {
+	case "redis":
+		pool := newRedisPool(conf.Redis)
+		// 启动时就检查一下能不能连上，而不是等到第一次查询才报错
+		conn := pool.Get()
+		_, err := conn.Do("PING")
+		conn.Close()
+		if err != nil {
+			pool.Close()
+			return nil, err
+		}
+		return &redisSeenStore{pool: pool, hash: conf.Seen.Key}, nil
+	case "bolt":
+		return openBoltSeenStore(conf.Seen.Path, conf.Seen.Key)
+	case "memory":
+		return newMemorySeenStore(conf.Seen.Capacity), nil
+	}
+	return nil, fmt.Errorf("unknown seen store %q", conf.Seen.Store)
+}
+
+// 创建redis连接池。连接断开后借出时会重新建立；
+// 空闲超过HealthCheckInterval的连接借出前先PING一下，不通就丢弃换一个。
+func newRedisPool(conf RedisConfig) *redis.Pool {
+	return &redis.Pool{
+		MaxIdle:     conf.MaxIdle,
+		MaxActive:   conf.MaxActive,
+		IdleTimeout: time.Duration(conf.IdleTimeout),
+		Wait:        true, // 连接数达到MaxActive时等待，而不是返回错误
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", conf.Addr,
+				redis.DialPassword(conf.Password),
+				redis.DialDatabase(conf.DB),
+				redis.DialConnectTimeout(time.Duration(conf.DialTimeout)),
+				redis.DialReadTimeout(time.Duration(conf.ReadTimeout)),
+				redis.DialWriteTimeout(time.Duration(conf.WriteTimeout)))
+		},
+		TestOnBorrow: func(conn redis.Conn, lastUsed time.Time) error {
+			if time.Since(lastUsed) < time.Duration(conf.HealthCheckInterval) {
+				return nil
+			}
+			_, err := conn.Do("PING")
+			return err
+		},
+	}
+}
+
+// 用redis的hash记录，hash的field是key
+type redisSeenStore struct {
+	pool *redis.Pool
+	hash string
+}
+
+func (s *redisSeenStore) Seen(key string) (bool, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+	return redis.Bool(conn.Do("HEXISTS", s.hash, key))
+}
+
+// 用pipeline一次发送所有HEXISTS命令，只需要一次网络往返
+func (s *redisSeenStore) SeenBatch(keys []string) ([]bool, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+	for _, key := range keys {
+		if err := conn.Send("HEXISTS", s.hash, key); err != nil {
+			return nil, err
+		}
+	}
+	if err := conn.Flush(); err != nil {
+		return nil, err
+	}
+	seen := make([]bool, len(keys))
+	for i := range keys {
+		exists, err := redis.Bool(conn.Receive())
+		if err != nil {
+			return nil, err
+		}
+		seen[i] = exists
+	}
+	return seen, nil
+}
+
+func (s *redisSeenStore) MarkSeen(key string) error {
+	conn := s.pool.Get()
+	defer conn.Close()
+	_, err := conn.Do("HSET", s.hash, key, "1")
+	return err
+}
+
+func (s *redisSeenStore) Close() error {
+	return s.pool.Close()
+}
+
+// 用本地的bbolt数据库文件记录，不需要额外的服务
+type boltSeenStore struct {
+	db     *bolt.DB
+	bucket []byte
+}
+
+func openBoltSeenStore(path, bucket string) (*boltSeenStore, error) {
+	db, err := bolt.Open(path, 0644, nil)
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(bucket))
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &boltSeenStore{db: db, bucket: []byte(bucket)}, nil
+}
+
+func (s *boltSeenStore) Seen(key string) (bool, error) {
+	var seen bool
+	err := s.db.View(func(tx *bolt.Tx) error {
+		seen = tx.Bucket(s.bucket).Get([]byte(key)) != nil
+		return nil
+	})
+	return seen, err
+}
+
+func (s *boltSeenStore) SeenBatch(keys []string) ([]bool, error) {
+	seen := make([]bool, len(keys))
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(s.bucket)
+		for i, key := range keys {
+			seen[i] = bucket.Get([]byte(key)) != nil
+		}
+		return nil
+	})
+	return seen, err
+}
+
+func (s *boltSeenStore) MarkSeen(key string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(s.bucket).Put([]byte(key), []byte("1"))
+	})
+}
+
+func (s *boltSeenStore) Close() error {
+	return s.db.Close()
+}
+
+// 记录在内存中，进程退出后就丢失，适合测试和一次性爬取。
+// capacity大于0时最多记住capacity个最近爬取的key，更早的会被忘掉。
+type memorySeenStore struct {
+	cache *cache.Cache
+}
+
+func newMemorySeenStore(capacity int) *memorySeenStore {
+	return &memorySeenStore{cache: cache.NewCache(capacity)}
+}
+
+func (s *memorySeenStore) Seen(key string) (bool, error) {
+	return s.cache.Contains(key), nil
+}
+
+func (s *memorySeenStore) SeenBatch(keys []string) ([]bool, error) {
+	seen := make([]bool, len(keys))
+	for i, key := range keys {
+		seen[i] = s.cache.Contains(key)
+	}
+	return seen, nil
+}
+
+func (s *memorySeenStore) MarkSeen(key string) error {
+	s.cache.Put(key, struct{}{})
+	return nil
+}
+
+func (s *memorySeenStore) Close() error {
+	return s.cache.Close()
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// 进程内的假redis，只实现redisSeenStore用到的命令
+type fakeRedis struct {
+	lock   sync.Mutex
+	hashes map[string]map[string]string
+}
+
+func startFakeRedis(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	server := &fakeRedis{hashes: make(map[string]map[string]string)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func (s *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r, w := bufio.NewReader(conn), bufio.NewWriter(conn)
+	for {
+		args, err := readFakeCommand(r)
+		if err != nil {
+			return
+		}
+		s.lock.Lock()
+		switch strings.ToUpper(args[0]) {
+		case "PING":
+			w.WriteString("+PONG\r\n")
+		case "HEXISTS":
+			_, ok := s.hashes[args[1]][args[2]]
+			if ok {
+				w.WriteString(":1\r\n")
+			} else {
+				w.WriteString(":0\r\n")
+			}
+		case "HSET":
+			if s.hashes[args[1]] == nil {
+				s.hashes[args[1]] = make(map[string]string)
+			}
+			s.hashes[args[1]][args[2]] = args[3]
+			w.WriteString(":1\r\n")
+		default:
+			fmt.Fprintf(w, "-ERR unknown command '%s'\r\n", args[0])
+		}
+		s.lock.Unlock()
+		if r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func readFakeCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil || n <= 0 {
+		return nil, fmt.Errorf("bad command %q", line)
+	}
+	args := make([]string, n)
+	for i := range args {
+		if _, err := r.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		arg, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		args[i] = strings.TrimSuffix(arg, "\r\n")
+	}
+	return args, nil
+}
+
+func TestSeenStores(t *testing.T) {
+	tests := []struct {
+		name string
+		seen SeenConfig
+	}{
+		{"memory", SeenConfig{Store: "memory", Key: "kongjie"}},
+		{"bolt", SeenConfig{Store: "bolt", Key: "kongjie", Path: filepath.Join(t.TempDir(), "seen.db")}},
+		{"redis", SeenConfig{Store: "redis", Key: "kongjie"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conf := defaultConfig()
+			conf.Seen = test.seen
+			conf.Redis.Addr = startFakeRedis(t)
+			store, err := newSeenStore(conf)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if seen, err := store.Seen("1:1"); err != nil || seen {
+				t.Fatalf("Seen before MarkSeen = %v, %v", seen, err)
+			}
+			for _, key := range []string{"1:1", "2:2"} {
+				if err := store.MarkSeen(key); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if seen, err := store.Seen("1:1"); err != nil || !seen {
+				t.Fatalf("Seen after MarkSeen = %v, %v", seen, err)
+			}
+			seen, err := store.SeenBatch([]string{"2:2", "3:3", "1:1"})
+			if err != nil || !reflect.DeepEqual(seen, []bool{true, false, true}) {
+				t.Fatalf("SeenBatch = %v, %v", seen, err)
+			}
+			if seen, err := store.SeenBatch(nil); err != nil || len(seen) != 0 {
+				t.Fatalf("SeenBatch(nil) = %v, %v", seen, err)
+			}
+			if err := store.Close(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// bolt的记录在重新打开后还在
+func TestBoltSeenStoreReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "seen.db")
+	store, err := openBoltSeenStore(path, "kongjie")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.MarkSeen("1:1")
+	store.Close()
+
+	store, err = openBoltSeenStore(path, "kongjie")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if seen, err := store.Seen("1:1"); err != nil || !seen {
+		t.Fatalf("Seen after reopen = %v, %v", seen, err)
+	}
+}
+
+// capacity大于0时只记住最近的key
+func TestMemorySeenStoreCapacity(t *testing.T) {
+	store := newMemorySeenStore(2)
+	defer store.Close()
+	for _, key := range []string{"1", "2", "3"} {
+		store.MarkSeen(key)
+	}
+	seen, _ := store.SeenBatch([]string{"1", "2", "3"})
+	if !reflect.DeepEqual(seen, []bool{false, true, true}) {
+		t.Fatalf("SeenBatch = %v, want the oldest key forgotten", seen)
+	}
+}
+
+func TestNewSeenStoreErrors(t *testing.T) {
+	conf := defaultConfig()
+	conf.Seen.Store = "mysql"
+	if _, err := newSeenStore(conf); err == nil {
+		t.Fatal("unknown store did not return an error")
+	}
+
+	// 启动时就要发现redis连不上
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf.Seen.Store = "redis"
+	conf.Redis.Addr = listener.Addr().String()
+	listener.Close()
+	if _, err := newSeenStore(conf); err == nil {
+		t.Fatal("unreachable redis did not return an error")
+	}
+}
+
+// 列表页批量查询的结果和详情页一一对应，url中没有图片id时为nil
+func TestBatchSeen(t *testing.T) {
+	site, err := loadSite("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := seenStore
+	seenStore = newMemorySeenStore(0)
+	t.Cleanup(func() { seenStore = old })
+	seenStore.MarkSeen("10001:200001")
+
+	seen := batchSeen(site, []string{
+		"http://www.kongjie.com/home.php?mod=space&uid=10001&do=album&picid=200001",
+		"http://www.kongjie.com/home.php?mod=space&do=album",
+		"http://www.kongjie.com/home.php?mod=space&uid=10002&do=album&picid=200002",
+	})
+	if len(seen) != 3 || seen[0] == nil || !*seen[0] || seen[1] != nil || seen[2] == nil || *seen[2] {
+		t.Fatalf("batchSeen = %v", seen)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+
+	"github.com/andybalholm/cascadia"
+	"gopkg.in/yaml.v3"
+)
+
+// 站点定义：爬虫按“列表页 -> 详情页 -> 图片”的结构爬取，和具体网站相关的内容
+// （起始页、请求头、各个CSS选择器、图片id和文件名的规则）都写在YAML文件中，
+// 爬取其它Discuz!风格的相册只需要写一个站点定义文件，不需要改代码。
+
+//go:embed sites/kongjie.yaml
+var builtinSite []byte
+
+type Site struct {
+	Name     string              `yaml:"name"`
+	StartURL string              `yaml:"startUrl"` // 第一个列表页
+	Headers  map[string][]string `yaml:"headers"`  // 每个请求都会带上的header
+	List     struct {
+		Container string      `yaml:"container"` // 列表所在的元素，为空表示整个页面
+		Item      extractRule `yaml:"item"`      // 在Container中选出每个详情页链接
+		Next      extractRule `yaml:"next"`      // 下一个列表页
+		container cascadia.Matcher
+	} `yaml:"list"`
+	Detail struct {
+		Image extractRule `yaml:"image"` // 要保存的图片
+		Next  extractRule `yaml:"next"`  // 下一个详情页
+	} `yaml:"detail"`
+	ID struct {
+		Pattern  string `yaml:"pattern"`  // 从详情页url中提取id的正则表达式
+		Key      string `yaml:"key"`      // 判断图片是否爬取过的key，用${1}引用pattern的子匹配组
+		FileName string `yaml:"fileName"` // 保存的文件名（不含扩展名），为空时和Key相同
+		pattern  *regexp.Regexp
+	} `yaml:"id"`
+}
+
+// 读取站点定义文件，path为空时使用内置的空姐网定义
+func loadSite(path string) (*Site, error) {
+	data := builtinSite
+	if path != "" {
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	site := &Site{}
+	if err := yaml.Unmarshal(data, site); err != nil {
+		return nil, fmt.Errorf("parse site %s: %v", path, err)
+	}
+	if err := site.compile(); err != nil {
+		return nil, fmt.Errorf("invalid site %s: %w", site.Name, err)
+	}
+	return site, nil
+}
+
+// 检查必填项，编译选择器和正则表达式，一次返回所有的错误
+func (site *Site) compile() error {
+	var errs []error
+	if site.StartURL == "" {
+		errs = append(errs, errors.New("startUrl must not be empty"))
+	}
+	if site.List.Container != "" {
+		sel, err := cascadia.Compile(site.List.Container)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("list.container: %v", err))
+		}
+		site.List.container = sel
+	}
+	rules := []struct {
+		name     string
+		rule     *extractRule
+		required bool
+	}{
+		{"list.item", &site.List.Item, true},
+		{"list.next", &site.List.Next, false},
+		{"detail.image", &site.Detail.Image, true},
+		{"detail.next", &site.Detail.Next, false},
+	}
+	for _, r := range rules {
+		if r.rule.Selector == "" {
+			if r.required {
+				errs = append(errs, fmt.Errorf("%s.selector must not be empty", r.name))
+			}
+			continue
+		}
+		if err := r.rule.compile(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", r.name, err))
+		}
+	}
+
+	pattern, err := regexp.Compile(site.ID.Pattern)
+	if err != nil || site.ID.Pattern == "" {
+		errs = append(errs, fmt.Errorf("id.pattern must be a non-empty regexp, got %q", site.ID.Pattern))
+	}
+	site.ID.pattern = pattern
+	if site.ID.Key == "" {
+		errs = append(errs, errors.New("id.key must not be empty"))
+	}
+	if site.ID.FileName == "" {
+		site.ID.FileName = site.ID.Key
+	}
+	return errors.Join(errs...)
+}
+
+// 从详情页url中提取图片的key和文件名，url不符合id.pattern时ok为false
+func (site *Site) imageID(detailUrl string) (key, fileName string, ok bool) {
+	match := site.ID.pattern.FindStringSubmatchIndex(detailUrl)
+	if match == nil {
+		return "", "", false
+	}
+	key = string(site.ID.pattern.ExpandString(nil, site.ID.Key, detailUrl, match))
+	fileName = string(site.ID.pattern.ExpandString(nil, site.ID.FileName, detailUrl, match))
+	return key, fileName, true
+}
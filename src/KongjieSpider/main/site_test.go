@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSiteImageID(t *testing.T) {
+	site, err := loadSite("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, fileName, ok := site.imageID("http://www.kongjie.com/home.php?mod=space&uid=10001&do=album&picid=200001")
+	if !ok || key != "10001:200001" || fileName != "10001_200001" {
+		t.Fatalf("imageID = %q, %q, %v", key, fileName, ok)
+	}
+	if _, _, ok := site.imageID("http://www.kongjie.com/home.php?mod=space&do=album"); ok {
+		t.Fatal("imageID matched a url without uid and picid")
+	}
+}
+
+// 其它站点只需要写一个YAML文件，没有写fileName时和key相同
+func TestLoadSite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "site.yaml")
+	yaml := `
+name: example
+startUrl: http://bbs.example.com/album.php?page=1
+list:
+  item: {selector: "ul.albums a.cover", attr: href}
+detail:
+  image: {selector: "#photo img", attr: src}
+id:
+  pattern: aid=(\d+)
+  key: example-${1}
+`
+	if err := os.WriteFile(path, []byte(yaml), 0644); err != nil {
+		t.Fatal(err)
+	}
+	site, err := loadSite(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err := site.extractListPage(site.StartURL, []byte(`<ul class="albums"><li><a class="cover" href="photo.php?aid=7">x</a></li></ul>`))
+	if err != nil || len(page.Items) != 1 || page.Items[0] != "http://bbs.example.com/photo.php?aid=7" || page.Next != "" {
+		t.Fatalf("extractListPage = %+v, %v", page, err)
+	}
+	if key, fileName, _ := site.imageID(page.Items[0]); key != "example-7" || fileName != "example-7" {
+		t.Fatalf("imageID = %q, %q", key, fileName)
+	}
+
+	invalid := filepath.Join(t.TempDir(), "invalid.yaml")
+	if err := os.WriteFile(invalid, []byte("name: invalid\nlist:\n  item: {selector: 'a[', attr: href}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = loadSite(invalid)
+	for _, want := range []string{"startUrl", "list.item", "detail.image", "id.pattern", "id.key"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("loadSite(invalid) error %v does not mention %s", err, want)
+		}
+	}
+}
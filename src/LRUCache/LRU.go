@@ -1,91 +1,448 @@
 package cache
 
-import "sync"
+import (
+	"bytes"
+	"io"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
 
 // 1. 固定容量的缓存，当元素个数满了之后，往里面再放元素时会先删除最近最少使用的元素。
 // 2. 像map一样，快速存取元素
+// 3. 元素可以设置过期时间，过期的元素在Get时被惰性删除，也可以开启后台清理goroutine定期清理
+// 具体实现在泛型的LRU中，Cache是key为string、value为interface{}的LRU
 
-type Entry struct {
-	Key   string
-	Value interface{}
-	pre   *Entry
-	next  *Entry
-}
+type Entry = entry[string, interface{}]
 
 type Cache struct {
-	cache    map[string]*Entry
-	capacity int
-	head     *Entry
-	tail     *Entry
+	lru       *LRU[string, interface{}]
+	disk      *DiskStore    // 第二层磁盘存储，为nil表示没有开启
+	diskLocks []diskKeyLock // 按key分段，保证同一个key的磁盘读写和内存读写的先后顺序
 }
 
-func NewCache(cap int) *Cache {
-	return &Cache{cache: make(map[string]*Entry), capacity: cap}
+// 磁盘存储的分段锁个数
+const diskLockShards = 64
+
+// 一段key的磁盘锁。磁盘上的数据每变化一次gen加一，淘汰和放回内存的过程中gen变了，
+// 说明期间有新的写入或者删除，旧值不能再写入磁盘或者放回内存。gen只在持有锁时修改。
+type diskKeyLock struct {
+	sync.Mutex
+	gen atomic.Uint64
 }
 
-var lock sync.RWMutex
+// 元素被移除的原因
+type EvictReason int
 
-// 把元素放入缓存中，如果缓存满了，则删除最近最少使用的那个元素并返回，把新元素放入缓存中。
-// 如果缓存没满，把新元素放入缓存中并返回nil。
-func (cache *Cache) Put(key string, val interface{}) interface{} {
-	lock.Lock()
-	defer lock.Unlock()
+const (
+	EvictCapacity EvictReason = iota // 缓存满了，淘汰最近最少使用的元素
+	EvictExpired                     // 元素过期
+	EvictDeleted                     // 调用Delete删除
+	EvictPurged                      // 调用Purge清空
+)
 
-	if existVal, exist := cache.cache[key]; exist {
-		cache.moveToHead(existVal)
-		return nil
+func (r EvictReason) String() string {
+	switch r {
+	case EvictCapacity:
+		return "capacity"
+	case EvictExpired:
+		return "expired"
+	case EvictDeleted:
+		return "deleted"
+	case EvictPurged:
+		return "purged"
 	}
+	return "unknown"
+}
+
+// NewCache和NewLRU的可选配置
+type Option func(*options)
+
+type options struct {
+	ttl             time.Duration
+	janitorInterval time.Duration
+	maxCost         int64
+	sizer           Sizer
+
+	codec            Codec
+	snapshotPath     string
+	snapshotInterval time.Duration
 
-	e := &Entry{Key: key, Value: val, next: cache.head}
-	if cache.head != nil {
-		cache.head.pre = e
+	disk *DiskStore
+}
+
+// 计算元素开销的函数，比如返回value占用的字节数
+type Sizer func(key, value interface{}) int64
+
+// 设置默认过期时间，通过Put放入的元素在ttl之后过期
+func WithTTL(ttl time.Duration) Option {
+	return func(o *options) {
+		o.ttl = ttl
 	}
-	cache.head = e
-	if cache.tail == nil {
-		cache.tail = e
+}
+
+// 开启后台清理goroutine，每隔interval从队列尾部到头部扫描一遍，删除过期元素。
+// 开启之后不再使用缓存时需要调用Close，否则goroutine不会退出。
+func WithJanitor(interval time.Duration) Option {
+	return func(o *options) {
+		o.janitorInterval = interval
 	}
-	cache.cache[key] = e
+}
 
-	if len(cache.cache) <= cache.capacity {
-		return nil
+// 开启按开销淘汰：所有元素的开销之和超过maxCost时，从队列尾部开始淘汰。
+// 只想按开销淘汰时，把容量设置为0即可不限制元素个数。
+func WithMaxCost(maxCost int64) Option {
+	return func(o *options) {
+		o.maxCost = maxCost
 	}
+}
 
-	removedEntry := cache.tail
-	cache.tail = cache.tail.pre
-	removedEntry.pre = nil
-	cache.tail.next = nil
-	delete(cache.cache, removedEntry.Key)
-	return removedEntry.Value
+// 设置计算元素开销的函数，Put和PutWithTTL时用它计算开销。没有设置时每个元素的开销为1
+func WithSizer(sizer Sizer) Option {
+	return func(o *options) {
+		o.sizer = sizer
+	}
+}
+
+// 设置SaveTo、LoadFrom和定期快照使用的编码方式，默认为GobCodec
+func WithCodec(codec Codec) Option {
+	return func(o *options) {
+		o.codec = codec
+	}
+}
+
+// 开启定期快照：每隔interval把缓存中的所有元素保存到path文件中，Close时再保存一次。
+// 先写临时文件再重命名，进程在写快照时被杀掉也不会损坏已有的快照文件。
+// 快照不会自动加载，启动时需要先调用LoadFile恢复。
+func WithSnapshot(path string, interval time.Duration) Option {
+	return func(o *options) {
+		o.snapshotPath = path
+		o.snapshotInterval = interval
+	}
+}
+
+// 开启磁盘存储作为第二层缓存，只对Cache生效：因为容量被淘汰的元素用WithCodec设置的编码方式写入store，
+// Get和GetOK在内存中未命中时会查找store，找到后把元素重新放回内存。
+// Delete和Purge会同时删除store中的数据，Peek、Contains、Keys、Len等只查看内存中的元素。
+// store由调用者负责关闭，多个缓存（比如ShardedCache的各个分段）可以共用同一个store。
+func WithDiskTier(store *DiskStore) Option {
+	return func(o *options) {
+		o.disk = store
+	}
+}
+
+// 创建容量为cap的缓存，cap<=0表示不限制元素个数
+func NewCache(cap int, opts ...Option) *Cache {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	cache := &Cache{lru: NewLRU[string, interface{}](cap, opts...), disk: o.disk}
+	if cache.disk != nil {
+		cache.diskLocks = make([]diskKeyLock, diskLockShards)
+		cache.lru.spill = cache.spillToDisk
+	}
+	return cache
 }
 
-// 从缓存中获取元素
+// 把元素放入缓存中，如果缓存满了，则删除最近最少使用的那个元素并返回，把新元素放入缓存中。
+// 如果缓存没满，把新元素放入缓存中并返回nil。key已经存在时更新它的值并提到队列头部。
+// 元素的过期时间为NewCache时通过WithTTL设置的默认过期时间。
+func (cache *Cache) Put(key string, val interface{}) interface{} {
+	removed, _ := cache.lru.Put(key, val)
+	// 先写内存再删磁盘上的旧值，之后才写入磁盘的旧值会因为gen变化被丢弃
+	cache.dropFromDisk(key)
+	return removed
+}
+
+// 和Put一样，但是单独指定该元素的过期时间，ttl<=0表示永不过期
+func (cache *Cache) PutWithTTL(key string, val interface{}, ttl time.Duration) interface{} {
+	removed, _ := cache.lru.PutWithTTL(key, val, ttl)
+	// 先写内存再删磁盘上的旧值，之后才写入磁盘的旧值会因为gen变化被丢弃
+	cache.dropFromDisk(key)
+	return removed
+}
+
+// 和Put一样，但是直接指定该元素的开销，返回最先被淘汰的元素
+func (cache *Cache) PutWithCost(key string, val interface{}, cost int64) interface{} {
+	removed, _ := cache.lru.PutWithCost(key, val, cost)
+	// 先写内存再删磁盘上的旧值，之后才写入磁盘的旧值会因为gen变化被丢弃
+	cache.dropFromDisk(key)
+	return removed
+}
+
+// 从缓存中获取元素。
+// 命中时需要把元素提到队列头部，会修改链表，所以这里必须加写锁；
+// 读多的场景请使用ShardedCache，把竞争分散到多个分段上。
+// 元素过期时会被删除，返回nil。
+// 不存在和存入的值本身是nil这两种情况都返回nil，需要区分时请使用GetOK。
 func (cache *Cache) Get(key string) interface{} {
-	lock.Lock()
-	defer lock.Unlock()
+	val, _ := cache.GetOK(key)
+	return val
+}
+
+// 和Get一样，但是额外返回元素是否存在。从磁盘层读回的元素算作命中
+func (cache *Cache) GetOK(key string) (interface{}, bool) {
+	if val, ok := cache.lru.get(key, cache.disk == nil); ok || cache.disk == nil {
+		return val, ok
+	}
+	val, ok := cache.loadFromDisk(key)
+	if ok {
+		cache.lru.hits.Add(1)
+	} else {
+		cache.lru.misses.Add(1)
+	}
+	return val, ok
+}
+
+// 获取元素但不把它提到队列头部，不影响淘汰顺序
+func (cache *Cache) Peek(key string) (interface{}, bool) {
+	return cache.lru.Peek(key)
+}
+
+// 返回元素的剩余过期时间，0表示永不过期。元素不存在或者已经过期时返回false
+func (cache *Cache) TTL(key string) (time.Duration, bool) {
+	return cache.lru.TTL(key)
+}
+
+// 判断元素是否存在，不影响淘汰顺序
+func (cache *Cache) Contains(key string) bool {
+	return cache.lru.Contains(key)
+}
+
+// 缓存中的元素个数，包括已经过期但还没被删除的元素
+func (cache *Cache) Len() int {
+	return cache.lru.Len()
+}
+
+// 按从最近使用到最久未使用的顺序返回所有未过期元素的key
+func (cache *Cache) Keys() []string {
+	return cache.lru.Keys()
+}
+
+// 返回缓存统计信息的快照
+func (cache *Cache) Stats() Stats {
+	return cache.lru.Stats()
+}
+
+// 当前所有元素的开销之和
+func (cache *Cache) TotalCost() int64 {
+	return cache.lru.TotalCost()
+}
 
-	if existVal, exist := cache.cache[key]; exist {
-		// 把该元素提到队列头部
-		cache.moveToHead(existVal)
-		return existVal.Value
+// 修改缓存容量，元素个数超过新容量时从队列尾部开始淘汰，返回被淘汰的元素个数
+func (cache *Cache) Resize(newCap int) int {
+	return cache.lru.Resize(newCap)
+}
+
+// 返回最久未使用的未过期元素，不影响淘汰顺序
+func (cache *Cache) Oldest() (string, interface{}, bool) {
+	return cache.lru.Oldest()
+}
+
+// 返回最近使用的未过期元素，不影响淘汰顺序
+func (cache *Cache) Newest() (string, interface{}, bool) {
+	return cache.lru.Newest()
+}
+
+// 删除并返回最久未使用的未过期元素
+func (cache *Cache) RemoveOldest() (string, interface{}, bool) {
+	return cache.lru.RemoveOldest()
+}
+
+// 从最近使用到最久未使用依次对每个未过期元素调用fn，fn返回false时停止遍历。
+// 遍历期间持有读锁，fn中不能修改这个缓存（包括Get），否则会死锁；需要修改时请使用RangeSnapshot。
+func (cache *Cache) Range(fn func(key string, v interface{}) bool) {
+	cache.lru.Range(fn)
+}
+
+// 和Range一样，但是从最久未使用到最近使用遍历
+func (cache *Cache) RangeReverse(fn func(key string, v interface{}) bool) {
+	cache.lru.RangeReverse(fn)
+}
+
+// 和Range一样，但是在锁外调用fn，fn中可以任意访问这个缓存
+func (cache *Cache) RangeSnapshot(fn func(key string, v interface{}) bool) {
+	cache.lru.RangeSnapshot(fn)
+}
+
+// 和RangeSnapshot一样，但是从最久未使用到最近使用遍历
+func (cache *Cache) RangeReverseSnapshot(fn func(key string, v interface{}) bool) {
+	cache.lru.RangeReverseSnapshot(fn)
+}
+
+// 从缓存中删除元素，元素存在时返回true
+func (cache *Cache) Delete(key string) bool {
+	if cache.disk == nil {
+		return cache.lru.Delete(key)
 	}
-	return nil
+	// 先删磁盘：正在从磁盘放回内存的旧值因为gen变化放不回去，或者已经放回去了，接下来在内存中删掉。
+	// 删内存之前被淘汰的旧值可能在gen变化之后才写入磁盘，所以最后再删一次
+	onDisk := cache.dropFromDisk(key)
+	deleted := cache.lru.Delete(key)
+	cache.dropFromDisk(key)
+	return deleted || onDisk
 }
 
-// 把元素提到队列头部
-func (cache *Cache) moveToHead(e *Entry) {
-	// 元素存在，下面把元素放到最前面去
-	if e == cache.head {
+// 清空缓存，每个元素都会触发一次EvictPurged回调。开启了磁盘存储时同时清空磁盘上的数据
+func (cache *Cache) Purge() {
+	if cache.disk == nil {
+		cache.lru.Purge()
 		return
 	}
-	// 元素不是head，那么e.pre一定不为nil
-	e.pre.next = e.next
-	if e == cache.tail {
-		cache.tail = e.pre
-	} else {
-		e.next.pre = e.pre
+	// 和Delete一样，清空内存的前后都要清空磁盘
+	cache.purgeDisk()
+	cache.lru.Purge()
+	cache.purgeDisk()
+}
+
+// 设置元素被移除时的回调函数，容量淘汰、过期、Delete和Purge都会触发。
+// 回调在释放锁之后执行，所以回调里可以继续访问缓存。传nil取消回调。
+func (cache *Cache) OnEvict(fn func(key string, value interface{}, reason EvictReason)) {
+	cache.lru.OnEvict(fn)
+}
+
+// 把缓存中所有未过期的元素按从最近使用到最久未使用的顺序写入w
+func (cache *Cache) SaveTo(w io.Writer) error {
+	return cache.lru.SaveTo(w)
+}
+
+// 从r中读取SaveTo写入的元素放入缓存，读取完成后元素的先后顺序和保存时相同
+func (cache *Cache) LoadFrom(r io.Reader) error {
+	return cache.lru.LoadFrom(r)
+}
+
+// 把快照原子地保存到path文件中
+func (cache *Cache) SaveFile(path string) error {
+	return cache.lru.SaveFile(path)
+}
+
+// 从path文件中恢复快照
+func (cache *Cache) LoadFile(path string) error {
+	return cache.lru.LoadFile(path)
+}
+
+// 停止后台清理goroutine和定期快照goroutine，可以重复调用。
+// 开启了定期快照时，关闭前会再保存一次快照，返回保存时的错误。
+func (cache *Cache) Close() error {
+	return cache.lru.Close()
+}
+
+// 写入磁盘的元素
+type diskEntry struct {
+	Value    interface{}
+	ExpireAt time.Time
+}
+
+func (cache *Cache) diskLock(key string) *diskKeyLock {
+	// 用哈希值的高位选择分段，ShardedCache已经用低位选择了缓存分段
+	return &cache.diskLocks[(fnv32a(key)>>16)%diskLockShards]
+}
+
+// 在持有缓存的锁时调用，记下淘汰时的gen；返回的函数在释放锁之后编码并写入磁盘，
+// 期间key被重新写入或者删除过的话就不再写入
+func (cache *Cache) spillToDisk(key string, val interface{}, expireAt time.Time) func() {
+	l := cache.diskLock(key)
+	gen := l.gen.Load()
+	return func() {
+		var buf bytes.Buffer
+		if err := cache.lru.codec.NewEncoder(&buf).Encode(diskEntry{Value: val, ExpireAt: expireAt}); err != nil {
+			log.Println("cache: encode evicted entry failed!", key, err)
+			return
+		}
+		l.Lock()
+		defer l.Unlock()
+		if l.gen.Load() != gen {
+			return
+		}
+		l.gen.Add(1)
+		if err := cache.disk.Put(key, buf.Bytes()); err != nil {
+			log.Println("cache: spill evicted entry to disk failed!", key, err)
+		}
+	}
+}
+
+// 从磁盘中读取元素并放回内存，磁盘上的数据随即删除。
+// 读取之后key被写入或者删除过的话不放回内存，以内存中的值为准
+func (cache *Cache) loadFromDisk(key string) (interface{}, bool) {
+	l := cache.diskLock(key)
+	l.Lock()
+	gen := l.gen.Load()
+	data, ok, err := cache.disk.Get(key)
+	l.Unlock()
+	if err != nil {
+		log.Println("cache: read disk tier failed!", key, err)
+		return nil, false
+	}
+	if !ok {
+		return nil, false
+	}
+
+	var e diskEntry
+	if err := cache.lru.codec.NewDecoder(bytes.NewReader(data)).Decode(&e); err != nil {
+		log.Println("cache: decode entry from disk failed!", key, err)
+		cache.dropFromDiskIf(key, gen)
+		return nil, false
+	}
+	if !e.ExpireAt.IsZero() && cache.lru.now().After(e.ExpireAt) {
+		cache.dropFromDiskIf(key, gen)
+		return nil, false
+	}
+	cost := int64(1)
+	if cache.lru.sizer != nil {
+		cost = cache.lru.sizer(key, e.Value)
+	}
+	if !cache.lru.putIfAbsent(key, e.Value, e.ExpireAt, cost, func() bool { return l.gen.Load() == gen }) {
+		return cache.lru.Peek(key)
+	}
+	// 内存中已经是最新的值了，磁盘上的数据没用了。gen加一，放回之前被挤出去的旧值也不会再写入磁盘
+	cache.dropFromDisk(key)
+	return e.Value, true
+}
+
+// 删除磁盘上的旧数据，避免内存中的新值过期或者被删除之后又从磁盘上读到旧值。返回磁盘上是否有这个key
+func (cache *Cache) dropFromDisk(key string) bool {
+	if cache.disk == nil {
+		return false
+	}
+	l := cache.diskLock(key)
+	l.Lock()
+	defer l.Unlock()
+	l.gen.Add(1)
+	existed, err := cache.disk.remove(key)
+	if err != nil {
+		log.Println("cache: delete from disk tier failed!", key, err)
+	}
+	return existed
+}
+
+// gen没有变化时才删除磁盘上的数据，变化了说明磁盘上已经是更新的数据
+func (cache *Cache) dropFromDiskIf(key string, gen uint64) {
+	l := cache.diskLock(key)
+	l.Lock()
+	defer l.Unlock()
+	if l.gen.Load() != gen {
+		return
+	}
+	l.gen.Add(1)
+	if _, err := cache.disk.remove(key); err != nil {
+		log.Println("cache: delete from disk tier failed!", key, err)
+	}
+}
+
+// 清空磁盘上的数据，所有分段的gen都加一
+func (cache *Cache) purgeDisk() {
+	for i := range cache.diskLocks {
+		cache.diskLocks[i].Lock()
+		cache.diskLocks[i].gen.Add(1)
+	}
+	defer func() {
+		for i := range cache.diskLocks {
+			cache.diskLocks[i].Unlock()
+		}
+	}()
+	if err := cache.disk.Purge(); err != nil {
+		log.Println("cache: purge disk tier failed!", err)
 	}
-	e.pre = nil
-	e.next = cache.head
-	cache.head.pre = e
-	cache.head = e
 }
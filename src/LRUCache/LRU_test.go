@@ -1,25 +1,45 @@
 package cache
 
 import (
+	"fmt"
 	"math/rand"
 	"strconv"
 	"testing"
+	"time"
 )
 
 func TestLRU(t *testing.T) {
 	cache := NewCache(2)
 	cache.Put("1", "one")
-	t.Log(cache.Get("1"))
-	cache.Put("2", "two")
-	t.Log(cache.Get("1"))
-	cache.Put("3", "three")
-	t.Log(cache.Get("2"))
-	t.Log(cache.Get("3"))
-	t.Log(cache.Get("3"))
-	t.Log(cache.Get("1"))
+	if v := cache.Get("1"); v != "one" {
+		t.Fatalf("Get(1) = %v, want one", v)
+	}
 	cache.Put("2", "two")
-	t.Log(cache.Get("3"))
-	t.Log(cache.Get("1"))
+	if v := cache.Get("1"); v != "one" {
+		t.Fatalf("Get(1) = %v, want one", v)
+	}
+	// 1刚被访问过，淘汰的是2
+	if removed := cache.Put("3", "three"); removed != "two" {
+		t.Fatalf("Put(3) evicted %v, want two", removed)
+	}
+	if v := cache.Get("2"); v != nil {
+		t.Fatalf("Get(2) = %v, want nil", v)
+	}
+	if v := cache.Get("3"); v != "three" {
+		t.Fatalf("Get(3) = %v, want three", v)
+	}
+	if v := cache.Get("1"); v != "one" {
+		t.Fatalf("Get(1) = %v, want one", v)
+	}
+	if removed := cache.Put("2", "two"); removed != "three" {
+		t.Fatalf("Put(2) evicted %v, want three", removed)
+	}
+	if v := cache.Get("3"); v != nil {
+		t.Fatalf("Get(3) = %v, want nil", v)
+	}
+	if got := fmt.Sprint(cache.Keys()); got != "[2 1]" {
+		t.Fatalf("Keys() = %s, want [2 1]", got)
+	}
 }
 
 func Benchmark_Put(t *testing.B) {
@@ -36,3 +56,376 @@ func Benchmark_PutGet(t *testing.B) {
 		cache.Get(strconv.Itoa(rand.Intn(2000)))
 	}
 }
+
+func TestShardedCache(t *testing.T) {
+	cache := NewShardedCache(64, 8)
+	if cache.ShardNum() != 8 {
+		t.Fatalf("ShardNum() = %d, want 8", cache.ShardNum())
+	}
+	// 分段容量之和正好是总容量，key按fnv32a分布，有4个key所在的分段满了被提前淘汰
+	hits := 0
+	for i := 0; i < 64; i++ {
+		cache.Put(strconv.Itoa(i), i)
+	}
+	for i := 0; i < 64; i++ {
+		v := cache.Get(strconv.Itoa(i))
+		if v == nil {
+			continue
+		}
+		if v != i {
+			t.Fatalf("Get(%d) = %v, want %d", i, v, i)
+		}
+		hits++
+	}
+	if n := cache.Len(); hits != 60 || n != 60 {
+		t.Fatalf("%d hits and Len() = %d after 64 puts, want 60", hits, n)
+	}
+	cache.Put("hot", "value")
+	if v := cache.Get("hot"); v != "value" {
+		t.Fatalf("Get(hot) = %v, want value", v)
+	}
+
+	// 分段不多于key时每个key都放得下
+	for _, test := range []struct{ capacity, shardNum, wantShards int }{
+		{1, 8, 1},
+		{3, 8, 3},
+		{10, 4, 4},
+		{100, 7, 7},
+	} {
+		cache := NewShardedCache(test.capacity, test.shardNum)
+		if cache.ShardNum() != test.wantShards {
+			t.Errorf("NewShardedCache(%d, %d).ShardNum() = %d, want %d", test.capacity, test.shardNum, cache.ShardNum(), test.wantShards)
+		}
+		for i := 0; i < test.capacity*10; i++ {
+			cache.Put(strconv.Itoa(i), i)
+			if n := cache.Len(); n > test.capacity {
+				t.Fatalf("NewShardedCache(%d, %d).Len() = %d, more than the capacity", test.capacity, test.shardNum, n)
+			}
+		}
+	}
+	// 容量<=0时不限制个数
+	unlimited := NewShardedCache(0, 4)
+	for i := 0; i < 1000; i++ {
+		unlimited.Put(strconv.Itoa(i), i)
+	}
+	if n := unlimited.Len(); n != 1000 {
+		t.Fatalf("unlimited Len() = %d, want 1000", n)
+	}
+}
+
+func TestShardedCacheResize(t *testing.T) {
+	fill := func(cache *ShardedCache, n int) {
+		for i := 0; i < n; i++ {
+			cache.Put(strconv.Itoa(i), i)
+		}
+	}
+
+	// 容量<=0时不限制个数，缩容到0也一样
+	unlimited := NewShardedCache(0, 4)
+	fill(unlimited, 100)
+	if n := unlimited.Resize(0); n != 0 || unlimited.Len() != 100 {
+		t.Fatalf("Resize(0) on an unlimited cache evicted %d, Len() = %d, want 0 and 100", n, unlimited.Len())
+	}
+
+	cache := NewShardedCache(64, 8)
+	fill(cache, 64)
+	before := cache.Len()
+	for _, capacity := range []int{20, 3, 1, 8, 100} {
+		n := cache.Resize(capacity)
+		if cache.Len() > capacity || before-n != cache.Len() {
+			t.Fatalf("Resize(%d) evicted %d of %d, Len() = %d", capacity, n, before, cache.Len())
+		}
+		// 分段个数不变，容量小于分段个数时也不能超过总容量
+		fill(cache, 1000)
+		if cache.Len() > capacity {
+			t.Fatalf("after Resize(%d) Len() = %d", capacity, cache.Len())
+		}
+		before = cache.Len()
+	}
+	cache.Resize(-1)
+	fill(cache, 1000)
+	if n := cache.Len(); n != 1000 {
+		t.Fatalf("after Resize(-1) Len() = %d, want 1000", n)
+	}
+}
+
+func TestCacheInstancesIndependent(t *testing.T) {
+	c1 := NewCache(1)
+	c2 := NewCache(1)
+	c1.Put("k", 1)
+	c2.Put("k", 2)
+	if c1.Get("k") != 1 || c2.Get("k") != 2 {
+		t.Fatalf("caches share state: %v %v", c1.Get("k"), c2.Get("k"))
+	}
+}
+
+func Benchmark_PutParallel(t *testing.B) {
+	cache := NewCache(600)
+	t.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			cache.Put(strconv.Itoa(i), i)
+			i++
+		}
+	})
+}
+
+func Benchmark_PutGetParallel(t *testing.B) {
+	cache := NewCache(600)
+	t.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			cache.Put(strconv.Itoa(i), i)
+			cache.Get(strconv.Itoa(rand.Intn(2000)))
+			i++
+		}
+	})
+}
+
+func Benchmark_ShardedPutParallel(t *testing.B) {
+	cache := NewShardedCache(600, 16)
+	t.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			cache.Put(strconv.Itoa(i), i)
+			i++
+		}
+	})
+}
+
+func Benchmark_ShardedPutGetParallel(t *testing.B) {
+	cache := NewShardedCache(600, 16)
+	t.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			cache.Put(strconv.Itoa(i), i)
+			cache.Get(strconv.Itoa(rand.Intn(2000)))
+			i++
+		}
+	})
+}
+
+func TestTTL(t *testing.T) {
+	cache := NewCache(10, WithTTL(20*time.Millisecond))
+	cache.Put("default", 1)
+	cache.PutWithTTL("long", 2, time.Hour)
+	cache.PutWithTTL("forever", 3, 0)
+	time.Sleep(40 * time.Millisecond)
+	if v := cache.Get("default"); v != nil {
+		t.Fatalf("Get(default) = %v, want expired", v)
+	}
+	if v := cache.Get("long"); v != 2 {
+		t.Fatalf("Get(long) = %v, want 2", v)
+	}
+	if v := cache.Get("forever"); v != 3 {
+		t.Fatalf("Get(forever) = %v, want 3", v)
+	}
+	cache.Put("default", 4)
+	if v := cache.Get("default"); v != 4 {
+		t.Fatalf("Get(default) after re-put = %v, want 4", v)
+	}
+}
+
+func TestJanitor(t *testing.T) {
+	cache := NewCache(10, WithJanitor(5*time.Millisecond))
+	defer cache.Close()
+	for i := 0; i < 5; i++ {
+		cache.PutWithTTL(strconv.Itoa(i), i, 10*time.Millisecond)
+	}
+	cache.Put("keep", "v")
+	time.Sleep(50 * time.Millisecond)
+	if n := cache.Len(); n != 1 {
+		t.Fatalf("janitor left %d entries, want 1", n)
+	}
+	cache.Close()
+	cache.Close()
+}
+
+func TestOnEvict(t *testing.T) {
+	cache := NewCache(2)
+	evicted := map[string]EvictReason{}
+	cache.OnEvict(func(key string, value interface{}, reason EvictReason) {
+		evicted[key] = reason
+		// 回调在锁外执行，可以继续访问缓存
+		cache.Get(key)
+	})
+	cache.Put("1", "one")
+	cache.Put("2", "two")
+	cache.Put("3", "three")
+	if evicted["1"] != EvictCapacity {
+		t.Fatalf("key 1 reason = %v, want capacity", evicted["1"])
+	}
+	if !cache.Delete("2") || cache.Delete("2") {
+		t.Fatal("Delete should report existence exactly once")
+	}
+	if evicted["2"] != EvictDeleted {
+		t.Fatalf("key 2 reason = %v, want deleted", evicted["2"])
+	}
+	cache.PutWithTTL("4", "four", time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	cache.Get("4")
+	if evicted["4"] != EvictExpired {
+		t.Fatalf("key 4 reason = %v, want expired", evicted["4"])
+	}
+	cache.Purge()
+	if evicted["3"] != EvictPurged {
+		t.Fatalf("key 3 reason = %v, want purged", evicted["3"])
+	}
+	if v := cache.Get("3"); v != nil {
+		t.Fatalf("Get after Purge = %v, want nil", v)
+	}
+}
+
+func TestMapAPI(t *testing.T) {
+	cache := NewCache(3)
+	cache.Put("1", "one")
+	cache.Put("2", nil)
+	cache.Put("3", "three")
+
+	if v, ok := cache.GetOK("2"); !ok || v != nil {
+		t.Fatalf("GetOK(2) = %v, %v, want nil, true", v, ok)
+	}
+	if _, ok := cache.GetOK("missing"); ok {
+		t.Fatal("GetOK(missing) reported ok")
+	}
+	// 2是最近访问的，Peek不改变顺序
+	if v, ok := cache.Peek("1"); !ok || v != "one" {
+		t.Fatalf("Peek(1) = %v, %v", v, ok)
+	}
+	if got := fmt.Sprint(cache.Keys()); got != "[2 3 1]" {
+		t.Fatalf("Keys() = %s, want [2 3 1]", got)
+	}
+	if !cache.Contains("3") || cache.Contains("4") {
+		t.Fatal("Contains reported wrong result")
+	}
+
+	// 已存在的key更新值
+	if removed := cache.Put("1", "uno"); removed != nil {
+		t.Fatalf("Put existing key evicted %v", removed)
+	}
+	if v := cache.Get("1"); v != "uno" {
+		t.Fatalf("Get(1) = %v, want uno", v)
+	}
+
+	if n := cache.Resize(1); n != 2 {
+		t.Fatalf("Resize(1) evicted %d, want 2", n)
+	}
+	if cache.Len() != 1 || !cache.Contains("1") {
+		t.Fatalf("after Resize Keys() = %v, want [1]", cache.Keys())
+	}
+	cache.Purge()
+	if cache.Len() != 0 || len(cache.Keys()) != 0 {
+		t.Fatal("Purge left entries")
+	}
+}
+
+func TestMaxCost(t *testing.T) {
+	cache := NewCache(0, WithMaxCost(10), WithSizer(func(key, value interface{}) int64 {
+		return int64(len(value.(string)))
+	}))
+	cache.Put("a", "aaaa")
+	cache.Put("b", "bbbb")
+	if cache.TotalCost() != 8 {
+		t.Fatalf("TotalCost() = %d, want 8", cache.TotalCost())
+	}
+	// 放入c之后开销为14，淘汰a之后降到上限
+	if removed := cache.Put("c", "cccccc"); removed != "aaaa" {
+		t.Fatalf("Put(c) evicted %v, want aaaa", removed)
+	}
+	if cache.Contains("a") || cache.TotalCost() != 10 {
+		t.Fatalf("Keys() = %v, TotalCost() = %d", cache.Keys(), cache.TotalCost())
+	}
+	// 放入d之后开销为14，淘汰b
+	cache.PutWithCost("d", "d", 4)
+	if cache.Contains("b") || cache.TotalCost() != 10 || cache.Len() != 2 {
+		t.Fatalf("Keys() = %v, TotalCost() = %d", cache.Keys(), cache.TotalCost())
+	}
+	// 更新已有元素时开销也随之更新
+	cache.PutWithCost("d", "d", 1)
+	if cache.TotalCost() != 7 {
+		t.Fatalf("TotalCost() after update = %d, want 7", cache.TotalCost())
+	}
+	cache.Delete("c")
+	if cache.TotalCost() != 1 {
+		t.Fatalf("TotalCost() after Delete = %d, want 1", cache.TotalCost())
+	}
+	// 单个元素超过上限时放不进去
+	cache.PutWithCost("huge", "x", 11)
+	if cache.Contains("huge") || cache.TotalCost() != 0 {
+		t.Fatalf("oversized entry kept, TotalCost() = %d", cache.TotalCost())
+	}
+}
+
+func TestRange(t *testing.T) {
+	cache := NewCache(10)
+	for i := 1; i <= 4; i++ {
+		cache.Put(strconv.Itoa(i), i)
+	}
+	cache.PutWithTTL("expired", 0, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+
+	var keys []string
+	cache.Range(func(key string, v interface{}) bool {
+		keys = append(keys, key)
+		return true
+	})
+	if got := fmt.Sprint(keys); got != "[4 3 2 1]" {
+		t.Fatalf("Range = %s, want [4 3 2 1]", got)
+	}
+
+	keys = nil
+	cache.RangeReverse(func(key string, v interface{}) bool {
+		keys = append(keys, key)
+		return len(keys) < 2
+	})
+	if got := fmt.Sprint(keys); got != "[1 2]" {
+		t.Fatalf("RangeReverse with early stop = %s, want [1 2]", got)
+	}
+
+	// 快照模式下可以在回调中修改缓存
+	keys = nil
+	cache.RangeReverseSnapshot(func(key string, v interface{}) bool {
+		keys = append(keys, key)
+		cache.Get(key)
+		return true
+	})
+	if got := fmt.Sprint(keys); got != "[1 2 3 4]" {
+		t.Fatalf("RangeReverseSnapshot = %s, want [1 2 3 4]", got)
+	}
+	cache.RangeSnapshot(func(key string, v interface{}) bool {
+		cache.Delete(key)
+		return true
+	})
+	if cache.Len() != 1 {
+		t.Fatalf("Len() after deleting in RangeSnapshot = %d, want only the expired entry", cache.Len())
+	}
+}
+
+func TestOldestNewest(t *testing.T) {
+	cache := NewCache(10)
+	if _, _, ok := cache.Oldest(); ok {
+		t.Fatal("Oldest on empty cache reported ok")
+	}
+	cache.PutWithTTL("expired", 0, time.Millisecond)
+	cache.Put("1", "one")
+	cache.Put("2", "two")
+	time.Sleep(5 * time.Millisecond)
+
+	if key, v, ok := cache.Oldest(); !ok || key != "1" || v != "one" {
+		t.Fatalf("Oldest() = %s, %v, %v", key, v, ok)
+	}
+	if key, _, _ := cache.Newest(); key != "2" {
+		t.Fatalf("Newest() = %s, want 2", key)
+	}
+
+	var reasons []EvictReason
+	cache.OnEvict(func(key string, value interface{}, reason EvictReason) {
+		reasons = append(reasons, reason)
+	})
+	if key, _, ok := cache.RemoveOldest(); !ok || key != "1" {
+		t.Fatalf("RemoveOldest() = %s, %v, want 1", key, ok)
+	}
+	if fmt.Sprint(reasons) != "[expired deleted]" || cache.Len() != 1 {
+		t.Fatalf("reasons = %v, Len() = %d", reasons, cache.Len())
+	}
+}
@@ -0,0 +1,161 @@
+package cache
+
+import "sync"
+
+// ARC（Adaptive Replacement Cache）淘汰策略：
+// t1存放只访问过一次的元素，t2存放访问过多次的元素，b1、b2分别记住从t1、t2淘汰的key。
+// 命中b1说明t1太小，命中b2说明t2太小，据此动态调整t1的目标大小p，
+// 从而在“最近使用”和“经常使用”之间自动取得平衡。
+
+type arcCache struct {
+	lock     sync.Mutex
+	capacity int
+	p        int // t1的目标大小
+
+	t1 *LRU[string, interface{}]
+	t2 *LRU[string, interface{}]
+	b1 *LRU[string, struct{}]
+	b2 *LRU[string, struct{}]
+}
+
+func newARC(capacity int) *arcCache {
+	return &arcCache{
+		capacity: capacity,
+		t1:       NewLRU[string, interface{}](0),
+		t2:       NewLRU[string, interface{}](0),
+		b1:       NewLRU[string, struct{}](0),
+		b2:       NewLRU[string, struct{}](0),
+	}
+}
+
+func (c *arcCache) Put(key string, val interface{}) interface{} {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	// 命中t1，第二次访问，移到t2
+	if c.t1.Contains(key) {
+		c.t1.Delete(key)
+		c.t2.Put(key, val)
+		return nil
+	}
+	if c.t2.Contains(key) {
+		c.t2.Put(key, val)
+		return nil
+	}
+
+	// 命中b1，增大t1的目标大小
+	if c.b1.Contains(key) {
+		delta := 1
+		if b1Len, b2Len := c.b1.Len(), c.b2.Len(); b2Len > b1Len {
+			delta = b2Len / b1Len
+		}
+		c.p = min(c.p+delta, c.capacity)
+
+		var removed interface{}
+		if c.t1.Len()+c.t2.Len() >= c.capacity {
+			removed = c.replace(false)
+		}
+		c.b1.Delete(key)
+		c.t2.Put(key, val)
+		return removed
+	}
+
+	// 命中b2，减小t1的目标大小
+	if c.b2.Contains(key) {
+		delta := 1
+		if b1Len, b2Len := c.b1.Len(), c.b2.Len(); b1Len > b2Len {
+			delta = b1Len / b2Len
+		}
+		c.p = max(c.p-delta, 0)
+
+		var removed interface{}
+		if c.t1.Len()+c.t2.Len() >= c.capacity {
+			removed = c.replace(true)
+		}
+		c.b2.Delete(key)
+		c.t2.Put(key, val)
+		return removed
+	}
+
+	// 全新的key
+	var removed interface{}
+	if c.t1.Len()+c.t2.Len() >= c.capacity {
+		removed = c.replace(false)
+	}
+	// 限制ghost列表的大小
+	if c.b1.Len() > c.capacity-c.p {
+		c.b1.RemoveOldest()
+	}
+	if c.b2.Len() > c.p {
+		c.b2.RemoveOldest()
+	}
+	c.t1.Put(key, val)
+	return removed
+}
+
+func (c *arcCache) Get(key string) interface{} {
+	val, _ := c.GetOK(key)
+	return val
+}
+
+func (c *arcCache) GetOK(key string) (interface{}, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if val, ok := c.t1.Peek(key); ok {
+		c.t1.Delete(key)
+		c.t2.Put(key, val)
+		return val, true
+	}
+	return c.t2.Get(key)
+}
+
+func (c *arcCache) Contains(key string) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.t1.Contains(key) || c.t2.Contains(key)
+}
+
+func (c *arcCache) Delete(key string) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.t1.Delete(key) || c.t2.Delete(key) {
+		return true
+	}
+	c.b1.Delete(key)
+	c.b2.Delete(key)
+	return false
+}
+
+func (c *arcCache) Len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.t1.Len() + c.t2.Len()
+}
+
+func (c *arcCache) Purge() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.t1.Purge()
+	c.t2.Purge()
+	c.b1.Purge()
+	c.b2.Purge()
+	c.p = 0
+}
+
+// 根据目标大小p决定从t1还是t2淘汰一个元素，被淘汰的key记入对应的ghost列表
+func (c *arcCache) replace(b2ContainsKey bool) interface{} {
+	t1Len := c.t1.Len()
+	if t1Len > 0 && (t1Len > c.p || (t1Len == c.p && b2ContainsKey)) {
+		key, val, _ := c.t1.RemoveOldest()
+		c.b1.Put(key, struct{}{})
+		return val
+	}
+	if key, val, ok := c.t2.RemoveOldest(); ok {
+		c.b2.Put(key, struct{}{})
+		return val
+	}
+	key, val, _ := c.t1.RemoveOldest()
+	c.b1.Put(key, struct{}{})
+	return val
+}
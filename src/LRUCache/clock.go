@@ -0,0 +1,167 @@
+package cache
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// CLOCK淘汰策略：近似的LRU，读多写少的场景下比Cache的并发性能更好。
+// Cache.Get需要把元素移到链表头部，每次读都要加写锁，并发读会被串行化；
+// ClockCache.Get只加读锁，把元素的访问标记置为1，不修改任何共享结构。
+// 淘汰时指针（hand）在环形数组上转圈，访问标记为1的元素清零后跳过（给它第二次机会），
+// 淘汰遇到的第一个访问标记为0的元素。
+
+type clockEntry struct {
+	key        string
+	value      interface{}
+	referenced atomic.Bool // 上次指针经过之后是否被访问过
+}
+
+type ClockCache struct {
+	lock     sync.RWMutex
+	capacity int
+	cache    map[string]int // key -> 元素在slots中的下标
+	slots    []*clockEntry
+	hand     int
+
+	hits      atomic.Uint64
+	misses    atomic.Uint64
+	evictions atomic.Uint64
+}
+
+// 创建容量为capacity的CLOCK缓存，capacity小于等于0时按1处理
+func NewClockCache(capacity int) *ClockCache {
+	if capacity <= 0 {
+		capacity = 1
+	}
+	return &ClockCache{
+		capacity: capacity,
+		cache:    make(map[string]int, capacity),
+		slots:    make([]*clockEntry, 0, capacity),
+	}
+}
+
+// 放入元素，缓存满了时返回被淘汰的元素，否则返回nil
+func (c *ClockCache) Put(key string, val interface{}) interface{} {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if i, exist := c.cache[key]; exist {
+		e := c.slots[i]
+		e.value = val
+		e.referenced.Store(true)
+		return nil
+	}
+
+	e := &clockEntry{key: key, value: val}
+	if len(c.slots) < c.capacity {
+		c.cache[key] = len(c.slots)
+		c.slots = append(c.slots, e)
+		return nil
+	}
+
+	// 新元素放在被淘汰元素的位置上，访问标记为0，指针转一圈回来之前没被访问过就会被淘汰
+	i := c.evict()
+	removed := c.slots[i].value
+	delete(c.cache, c.slots[i].key)
+	c.slots[i] = e
+	c.cache[key] = i
+	c.evictions.Add(1)
+	return removed
+}
+
+// 转动指针找到要淘汰的位置，最多转两圈
+func (c *ClockCache) evict() int {
+	for {
+		e := c.slots[c.hand]
+		i := c.hand
+		c.hand = (c.hand + 1) % len(c.slots)
+		if !e.referenced.Load() {
+			return i
+		}
+		e.referenced.Store(false)
+	}
+}
+
+// 获取元素，不存在时返回nil
+func (c *ClockCache) Get(key string) interface{} {
+	val, _ := c.GetOK(key)
+	return val
+}
+
+// 获取元素，并返回元素是否存在。只持有读锁，多个goroutine可以同时读。
+func (c *ClockCache) GetOK(key string) (interface{}, bool) {
+	c.lock.RLock()
+	i, exist := c.cache[key]
+	if !exist {
+		c.lock.RUnlock()
+		c.misses.Add(1)
+		return nil, false
+	}
+	e := c.slots[i]
+	val := e.value
+	// 标记已经是1时不再写，避免热点元素的缓存行在多个CPU之间来回同步
+	if !e.referenced.Load() {
+		e.referenced.Store(true)
+	}
+	c.lock.RUnlock()
+	c.hits.Add(1)
+	return val, true
+}
+
+// 判断元素是否存在，不影响淘汰顺序
+func (c *ClockCache) Contains(key string) bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	_, exist := c.cache[key]
+	return exist
+}
+
+// 删除元素，元素存在时返回true。最后一个位置上的元素会被移到空出来的位置。
+func (c *ClockCache) Delete(key string) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	i, exist := c.cache[key]
+	if !exist {
+		return false
+	}
+	delete(c.cache, key)
+	last := len(c.slots) - 1
+	if i != last {
+		c.slots[i] = c.slots[last]
+		c.cache[c.slots[i].key] = i
+	}
+	c.slots[last] = nil
+	c.slots = c.slots[:last]
+	if c.hand >= len(c.slots) {
+		c.hand = 0
+	}
+	return true
+}
+
+// 缓存中的元素个数
+func (c *ClockCache) Len() int {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return len(c.slots)
+}
+
+// 清空缓存
+func (c *ClockCache) Purge() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.cache = make(map[string]int, c.capacity)
+	c.slots = make([]*clockEntry, 0, c.capacity)
+	c.hand = 0
+}
+
+// 命中、未命中和淘汰的统计信息，没有过期和开销的概念
+func (c *ClockCache) Stats() Stats {
+	return Stats{
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Evictions: c.evictions.Load(),
+		Size:      c.Len(),
+	}
+}
@@ -0,0 +1,65 @@
+package cache
+
+import (
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+// 被访问过的元素有第二次机会，淘汰的是没被访问过的元素
+func TestClockSecondChance(t *testing.T) {
+	cache := NewClockCache(3)
+	cache.Put("a", 1)
+	cache.Put("b", 2)
+	cache.Put("c", 3)
+	cache.Get("a")
+	if removed := cache.Put("d", 4); removed != 2 {
+		t.Fatalf("Put(d) evicted %v, want 2", removed)
+	}
+	if !cache.Contains("a") || cache.Contains("b") {
+		t.Fatal("referenced entry a was evicted")
+	}
+
+	cache.Delete("a")
+	cache.Put("e", 5)
+	if cache.Len() != 3 {
+		t.Fatalf("Len() = %d, want 3", cache.Len())
+	}
+	stats := cache.Stats()
+	if stats.Hits != 1 || stats.Evictions != 1 || stats.Size != 3 {
+		t.Fatalf("Stats() = %+v", stats)
+	}
+}
+
+// 读多写少的并发场景：90%的Get，10%的Put
+func Benchmark_GetParallel(b *testing.B) {
+	b.Run("LRU", func(b *testing.B) {
+		benchmarkGetParallel(b, NewCache(1000))
+	})
+	b.Run("Sharded", func(b *testing.B) {
+		benchmarkGetParallel(b, NewShardedCache(1000, 16))
+	})
+	b.Run("CLOCK", func(b *testing.B) {
+		benchmarkGetParallel(b, NewClockCache(1000))
+	})
+}
+
+func benchmarkGetParallel(b *testing.B, cache Policy) {
+	keys := make([]string, 2000)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+		cache.Put(keys[i], i)
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		r := rand.New(rand.NewSource(rand.Int63()))
+		for pb.Next() {
+			key := keys[r.Intn(len(keys))]
+			if r.Intn(10) == 0 {
+				cache.Put(key, key)
+			} else {
+				cache.Get(key)
+			}
+		}
+	})
+}
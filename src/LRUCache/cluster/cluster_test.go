@@ -0,0 +1,263 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	cache "rv/LRUCache"
+)
+
+// 测试用的集群节点
+type testPeer struct {
+	server *httptest.Server
+	pool   *HTTPPool
+	group  *Group
+	loads  atomic.Int32 // 本节点Getter被调用的次数
+}
+
+// 在本地随机端口上启动n个节点，组成一个集群
+func startCluster(t *testing.T, n int) []*testPeer {
+	peers := make([]*testPeer, n)
+	urls := make([]string, n)
+	for i := range peers {
+		peer := &testPeer{}
+		mux := http.NewServeMux()
+		peer.server = httptest.NewServer(mux)
+		t.Cleanup(peer.server.Close)
+		peer.pool = NewHTTPPool(peer.server.URL)
+		mux.Handle(defaultBasePath, peer.pool)
+		self := peer.server.URL
+		peer.group = peer.pool.NewGroup("scores", 100, func(ctx context.Context, key string) ([]byte, error) {
+			peer.loads.Add(1)
+			if key == "bad" {
+				return nil, fmt.Errorf("no score for %s", key)
+			}
+			return []byte("score of " + key + " from " + self), nil
+		})
+		peers[i] = peer
+		urls[i] = self
+	}
+	for _, peer := range peers {
+		peer.pool.SetPeers(urls...)
+	}
+	return peers
+}
+
+func TestClusterOwnerLoadsOnce(t *testing.T) {
+	peers := startCluster(t, 3)
+	ctx := context.Background()
+
+	for i := 0; i < 30; i++ {
+		key := fmt.Sprintf("player-%d", i)
+		owner := peers[0].pool.ring.Get(key)
+		var first []byte
+		for _, peer := range peers {
+			val, err := peer.group.Get(ctx, key)
+			if err != nil {
+				t.Fatalf("Get(%s) on %s: %v", key, peer.server.URL, err)
+			}
+			if first == nil {
+				first = val
+			} else if string(val) != string(first) {
+				t.Fatalf("peers disagree on %s: %q vs %q", key, first, val)
+			}
+		}
+		if want := "score of " + key + " from " + owner; string(first) != want {
+			t.Fatalf("Get(%s) = %q, want value loaded by owner %s", key, first, owner)
+		}
+	}
+
+	total := int32(0)
+	for _, peer := range peers {
+		total += peer.loads.Load()
+	}
+	if total != 30 {
+		t.Fatalf("getters called %d times for 30 keys, want 30", total)
+	}
+}
+
+func TestClusterHotCache(t *testing.T) {
+	peers := startCluster(t, 3)
+	ctx := context.Background()
+
+	// 找一个不归属于peers[0]的key
+	key := ""
+	for i := 0; key == ""; i++ {
+		k := fmt.Sprintf("hot-%d", i)
+		if peers[0].pool.ring.Get(k) != peers[0].server.URL {
+			key = k
+		}
+	}
+	for i := 0; i < 10; i++ {
+		if _, err := peers[0].group.Get(ctx, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stats := peers[0].group.Stats()
+	if stats.PeerLoads != 1 || stats.CacheHits != 9 || stats.LocalLoads != 0 {
+		t.Fatalf("stats = %+v, want 1 peer load and 9 cache hits", stats)
+	}
+	if !peers[0].group.HotCache().Contains(key) || peers[0].group.MainCache().Contains(key) {
+		t.Fatal("remote key should live in the hot cache only")
+	}
+}
+
+func TestClusterConcurrentGets(t *testing.T) {
+	peers := startCluster(t, 3)
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := peers[i%3].group.Get(context.Background(), "popular"); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	total := int32(0)
+	for _, peer := range peers {
+		total += peer.loads.Load()
+	}
+	if total != 1 {
+		t.Fatalf("getters called %d times for one key, want 1", total)
+	}
+}
+
+func TestClusterPeerDown(t *testing.T) {
+	peers := startCluster(t, 2)
+	key := ""
+	for i := 0; key == ""; i++ {
+		k := fmt.Sprintf("down-%d", i)
+		if peers[0].pool.ring.Get(k) == peers[1].server.URL {
+			key = k
+		}
+	}
+	peers[1].server.Close()
+
+	// 归属节点宕机时在本地加载
+	val, err := peers[0].group.Get(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "score of " + key + " from " + peers[0].server.URL; string(val) != want {
+		t.Fatalf("Get = %q, want %q", val, want)
+	}
+	if stats := peers[0].group.Stats(); stats.PeerErrors != 1 || stats.LocalLoads != 1 {
+		t.Fatalf("stats = %+v", stats)
+	}
+}
+
+func TestClusterErrors(t *testing.T) {
+	peers := startCluster(t, 2)
+	if _, err := peers[0].group.Get(context.Background(), ""); err != ErrEmptyKey {
+		t.Fatalf("Get(\"\") err = %v", err)
+	}
+	if _, err := peers[0].group.Get(context.Background(), "bad"); err == nil {
+		t.Fatal("Getter error was not returned")
+	}
+
+	rec := httptest.NewRecorder()
+	peers[0].pool.ServeHTTP(rec, httptest.NewRequest("GET", defaultBasePath+"nosuchgroup/k", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("unknown group status = %d", rec.Code)
+	}
+}
+
+// 合并的加载不受第一个调用者的ctx影响：它放弃等待后，其它调用者仍然拿到结果
+func TestClusterLoadOutlivesCaller(t *testing.T) {
+	release := make(chan struct{})
+	var loadErr atomic.Value
+	pool := NewHTTPPool("http://self")
+	group := pool.NewGroup("slow", 10, func(ctx context.Context, key string) ([]byte, error) {
+		<-release
+		if err := ctx.Err(); err != nil {
+			loadErr.Store(err)
+		}
+		return []byte("value of " + key), nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := group.Get(ctx, "k")
+		first <- err
+	}()
+	second := make(chan []byte, 1)
+	go func() {
+		val, err := group.Get(context.Background(), "k")
+		if err != nil {
+			t.Error(err)
+		}
+		second <- val
+	}()
+
+	cancel()
+	if err := <-first; err != context.Canceled {
+		t.Fatalf("canceled Get = %v, want context.Canceled", err)
+	}
+	close(release)
+	if val := <-second; string(val) != "value of k" {
+		t.Fatalf("second Get = %q", val)
+	}
+	if err := loadErr.Load(); err != nil {
+		t.Fatalf("Getter saw canceled context: %v", err)
+	}
+	if n := group.Stats().LocalLoads; n != 1 {
+		t.Fatalf("Getter called %d times, want 1", n)
+	}
+}
+
+// 总是选中同一个远程节点
+type fixedPicker struct{ peer PeerGetter }
+
+func (p fixedPicker) PickPeer(key string) (PeerGetter, bool) { return p.peer, true }
+
+type peerFunc func(ctx context.Context, group, key string) ([]byte, error)
+
+func (f peerFunc) Get(ctx context.Context, group, key string) ([]byte, error) {
+	return f(ctx, group, key)
+}
+
+// 节点列表不一致时，本节点的Get正在向另一个节点获取key，那个节点又把同一个key的请求发回来，
+// serveGet不能合并到这个Get上等待，要在本地加载
+func TestClusterServeGetNotMergedWithGet(t *testing.T) {
+	asked := make(chan struct{})
+	release := make(chan struct{})
+	group := &Group{
+		name: "scores",
+		getter: func(ctx context.Context, key string) ([]byte, error) {
+			return []byte("local " + key), nil
+		},
+		peers: fixedPicker{peerFunc(func(ctx context.Context, group, key string) ([]byte, error) {
+			close(asked)
+			<-release
+			return []byte("remote " + key), nil
+		})},
+		mainCache: cache.NewCache(10),
+		hotCache:  cache.NewCache(10),
+	}
+
+	got := make(chan []byte, 1)
+	go func() {
+		val, err := group.Get(context.Background(), "k")
+		if err != nil {
+			t.Error(err)
+		}
+		got <- val
+	}()
+	<-asked
+	val, err := group.serveGet(context.Background(), "k")
+	close(release)
+	if err != nil || string(val) != "local k" {
+		t.Fatalf("serveGet = %q, %v, want local k", val, err)
+	}
+	if val := <-got; string(val) != "remote k" {
+		t.Fatalf("Get = %q, want remote k", val)
+	}
+}
@@ -0,0 +1,167 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	cache "rv/LRUCache"
+)
+
+// 缓存组（参考groupcache）：同一个名字的组分布在集群的所有节点上，每个key由一致性哈希决定唯一的归属节点。
+// 归属节点自己调用Getter加载数据并放入本地缓存；其它节点通过HTTP向归属节点获取，
+// 并把获取到的数据放入一个小的热点缓存，热门key不用每次都跨节点获取。
+
+// 在归属节点上加载key对应的数据
+type Getter func(ctx context.Context, key string) ([]byte, error)
+
+// 选择key的归属节点
+type PeerPicker interface {
+	// 返回key的归属节点，归属节点是自己时返回false
+	PickPeer(key string) (PeerGetter, bool)
+}
+
+// 从远程节点获取数据
+type PeerGetter interface {
+	Get(ctx context.Context, group, key string) ([]byte, error)
+}
+
+type Group struct {
+	name   string
+	getter Getter
+	peers  PeerPicker
+
+	mainCache *cache.Cache // 归属于本节点的key
+	hotCache  *cache.Cache // 归属于其它节点、但在本节点上被访问的key
+	hotTTL    time.Duration
+	loads     cache.FlightGroup // 本节点的Get，可能从归属节点获取
+	serves    cache.FlightGroup // 其它节点发来的请求，只在本地加载
+
+	stats groupStats
+}
+
+// 组的统计计数
+type GroupStats struct {
+	Gets       int64 // Get调用次数
+	CacheHits  int64 // 本地缓存（主缓存或者热点缓存）命中次数
+	PeerLoads  int64 // 从远程节点获取成功的次数
+	PeerErrors int64 // 从远程节点获取失败的次数
+	LocalLoads int64 // 调用Getter的次数
+	ServerGets int64 // 其它节点发来的请求次数
+}
+
+type groupStats struct {
+	gets, cacheHits, peerLoads, peerErrors, localLoads, serverGets atomic.Int64
+}
+
+var ErrEmptyKey = errors.New("cluster: empty key")
+
+// 获取key对应的数据，依次查找主缓存、热点缓存、归属节点，本节点是归属节点时调用Getter加载。
+// 远程节点出错时退回到在本地调用Getter。返回的切片是副本，可以随意修改。
+func (g *Group) Get(ctx context.Context, key string) ([]byte, error) {
+	if key == "" {
+		return nil, ErrEmptyKey
+	}
+	g.stats.gets.Add(1)
+	if val, ok := g.lookupCache(key); ok {
+		g.stats.cacheHits.Add(1)
+		return cloneBytes(val), nil
+	}
+	val, err := g.loads.Do(ctx, key, func() (interface{}, error) {
+		// 等待期间其它goroutine可能已经加载好了
+		if val, ok := g.lookupCache(key); ok {
+			return val, nil
+		}
+		// 加载由多个调用者共享，不能因为第一个调用者取消了就让其它调用者也失败
+		return g.load(context.WithoutCancel(ctx), key)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cloneBytes(val.([]byte)), nil
+}
+
+// 组名
+func (g *Group) Name() string {
+	return g.name
+}
+
+// 统计计数的快照
+func (g *Group) Stats() GroupStats {
+	return GroupStats{
+		Gets:       g.stats.gets.Load(),
+		CacheHits:  g.stats.cacheHits.Load(),
+		PeerLoads:  g.stats.peerLoads.Load(),
+		PeerErrors: g.stats.peerErrors.Load(),
+		LocalLoads: g.stats.localLoads.Load(),
+		ServerGets: g.stats.serverGets.Load(),
+	}
+}
+
+// 主缓存和热点缓存
+func (g *Group) MainCache() *cache.Cache { return g.mainCache }
+func (g *Group) HotCache() *cache.Cache  { return g.hotCache }
+
+func (g *Group) lookupCache(key string) ([]byte, bool) {
+	if val, ok := g.mainCache.GetOK(key); ok {
+		return val.([]byte), true
+	}
+	if val, ok := g.hotCache.GetOK(key); ok {
+		return val.([]byte), true
+	}
+	return nil, false
+}
+
+func (g *Group) load(ctx context.Context, key string) ([]byte, error) {
+	if g.peers != nil {
+		if peer, ok := g.peers.PickPeer(key); ok {
+			val, err := peer.Get(ctx, g.name, key)
+			if err == nil {
+				g.stats.peerLoads.Add(1)
+				g.hotCache.PutWithTTL(key, val, g.hotTTL)
+				return val, nil
+			}
+			g.stats.peerErrors.Add(1)
+		}
+	}
+	return g.loadLocally(ctx, key)
+}
+
+// 调用Getter加载数据并放入主缓存
+func (g *Group) loadLocally(ctx context.Context, key string) ([]byte, error) {
+	g.stats.localLoads.Add(1)
+	val, err := g.getter(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	val = cloneBytes(val)
+	g.mainCache.Put(key, val)
+	return val, nil
+}
+
+// 处理其它节点发来的请求：只查本地缓存或者调用Getter，不再转发给其它节点，避免节点列表不一致时来回转发
+func (g *Group) serveGet(ctx context.Context, key string) ([]byte, error) {
+	g.stats.serverGets.Add(1)
+	if val, ok := g.mainCache.GetOK(key); ok {
+		return val.([]byte), nil
+	}
+	// 不能和Get共用loads：节点列表不一致时，本节点的Get可能正在向发来请求的节点获取同一个key，
+	// 合并到它上面会互相等待，而且拿到的不是本地加载的数据
+	val, err := g.serves.Do(ctx, key, func() (interface{}, error) {
+		if val, ok := g.mainCache.GetOK(key); ok {
+			return val, nil
+		}
+		return g.loadLocally(context.WithoutCancel(ctx), key)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return val.([]byte), nil
+}
+
+func cloneBytes(b []byte) []byte {
+	c := make([]byte, len(b))
+	copy(c, b)
+	return c
+}
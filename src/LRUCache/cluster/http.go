@@ -0,0 +1,197 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	cache "rv/LRUCache"
+)
+
+// 基于HTTP的节点池：既是本节点对外提供数据的http.Handler，也负责用一致性哈希选出key的归属节点。
+// 节点之间通过 GET <节点地址><basePath><组名>/<key> 获取数据，响应体就是数据本身。
+
+const (
+	defaultBasePath    = "/_cache/"
+	defaultReplicas    = 50
+	defaultHotCapacity = 1024
+)
+
+// NewHTTPPool的可选配置
+type PoolOption func(*HTTPPool)
+
+// 节点之间请求的路径前缀，默认为/_cache/
+func WithBasePath(basePath string) PoolOption {
+	return func(p *HTTPPool) {
+		p.basePath = basePath
+	}
+}
+
+// 一致性哈希环上每个节点的虚拟节点个数，默认为50
+func WithReplicas(replicas int) PoolOption {
+	return func(p *HTTPPool) {
+		p.replicas = replicas
+	}
+}
+
+// 请求其它节点使用的http.Client，默认为超时5秒的客户端
+func WithHTTPClient(client *http.Client) PoolOption {
+	return func(p *HTTPPool) {
+		p.client = client
+	}
+}
+
+// 每个组的热点缓存容量和热点数据的过期时间，默认容量1024、永不过期。
+// 设置过期时间可以让其它节点上更新过的数据最终在本节点上生效。
+func WithHotCache(capacity int, ttl time.Duration) PoolOption {
+	return func(p *HTTPPool) {
+		p.hotCapacity = capacity
+		p.hotTTL = ttl
+	}
+}
+
+type HTTPPool struct {
+	self        string // 本节点地址，比如http://10.0.0.1:8000
+	basePath    string
+	replicas    int
+	client      *http.Client
+	hotCapacity int
+	hotTTL      time.Duration
+
+	lock   sync.RWMutex
+	ring   *Ring
+	peers  map[string]*httpGetter
+	groups map[string]*Group
+}
+
+// 创建节点池，self是本节点的地址，需要和SetPeers中本节点的地址完全一致
+func NewHTTPPool(self string, opts ...PoolOption) *HTTPPool {
+	p := &HTTPPool{
+		self:        self,
+		basePath:    defaultBasePath,
+		replicas:    defaultReplicas,
+		client:      &http.Client{Timeout: 5 * time.Second},
+		hotCapacity: defaultHotCapacity,
+		groups:      make(map[string]*Group),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	p.ring = NewRing(p.replicas, nil)
+	return p
+}
+
+// 设置集群中的所有节点（包括本节点），会替换之前设置的节点
+func (p *HTTPPool) SetPeers(peers ...string) {
+	ring := NewRing(p.replicas, nil)
+	ring.Add(peers...)
+	getters := make(map[string]*httpGetter, len(peers))
+	for _, peer := range peers {
+		getters[peer] = &httpGetter{baseURL: strings.TrimSuffix(peer, "/") + p.basePath, client: p.client}
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.ring = ring
+	p.peers = getters
+}
+
+// 返回key的归属节点，归属节点是本节点或者没有设置节点时返回false
+func (p *HTTPPool) PickPeer(key string) (PeerGetter, bool) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	peer := p.ring.Get(key)
+	if peer == "" || peer == p.self {
+		return nil, false
+	}
+	return p.peers[peer], true
+}
+
+// 创建缓存组并注册到节点池中，capacity为主缓存的容量。集群中每个节点都要用同样的名字创建同一个组
+func (p *HTTPPool) NewGroup(name string, capacity int, getter Getter) *Group {
+	g := &Group{
+		name:      name,
+		getter:    getter,
+		peers:     p,
+		mainCache: cache.NewCache(capacity),
+		hotCache:  cache.NewCache(p.hotCapacity),
+		hotTTL:    p.hotTTL,
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.groups[name] = g
+	return g
+}
+
+// 根据名字获取已经注册的组
+func (p *HTTPPool) Group(name string) *Group {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return p.groups[name]
+}
+
+// 处理其它节点发来的 GET <basePath><组名>/<key> 请求
+func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, p.basePath) {
+		http.NotFound(w, r)
+		return
+	}
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.EscapedPath(), p.basePath), "/", 2)
+	if len(parts) != 2 {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	groupName, err1 := url.PathUnescape(parts[0])
+	key, err2 := url.PathUnescape(parts[1])
+	if err1 != nil || err2 != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	g := p.Group(groupName)
+	if g == nil {
+		http.Error(w, "no such group: "+groupName, http.StatusNotFound)
+		return
+	}
+	val, err := g.serveGet(r.Context(), key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	_, _ = w.Write(val)
+}
+
+// 通过HTTP从一个远程节点获取数据
+type httpGetter struct {
+	baseURL string
+	client  *http.Client
+}
+
+func (h *httpGetter) Get(ctx context.Context, group, key string) ([]byte, error) {
+	u := h.baseURL + url.PathEscape(group) + "/" + url.PathEscape(key)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := h.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("cluster: peer %s returned %s: %s", h.baseURL, res.Status, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
@@ -0,0 +1,90 @@
+package cluster
+
+import (
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// 一致性哈希环：每个节点在环上放replicas个虚拟节点，key顺时针找到的第一个虚拟节点所属的节点就是它的归属节点。
+// 增删节点时只有相邻区间的key会换归属节点，虚拟节点让key在各节点之间分布得更均匀。
+
+type HashFunc func(data []byte) uint32
+
+type Ring struct {
+	lock     sync.RWMutex
+	replicas int
+	hash     HashFunc
+	hashes   []uint32          // 所有虚拟节点的哈希值，从小到大排列
+	nodes    map[uint32]string // 虚拟节点哈希值 -> 节点
+}
+
+// 创建一致性哈希环，replicas为每个节点的虚拟节点个数，hash为nil时使用crc32
+func NewRing(replicas int, hash HashFunc) *Ring {
+	if replicas <= 0 {
+		replicas = 1
+	}
+	if hash == nil {
+		hash = crc32.ChecksumIEEE
+	}
+	return &Ring{replicas: replicas, hash: hash, nodes: make(map[uint32]string)}
+}
+
+// 添加节点
+func (r *Ring) Add(nodes ...string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for _, node := range nodes {
+		for i := 0; i < r.replicas; i++ {
+			h := r.hash([]byte(strconv.Itoa(i) + node))
+			if _, exist := r.nodes[h]; exist {
+				continue
+			}
+			r.nodes[h] = node
+			r.hashes = append(r.hashes, h)
+		}
+	}
+	sort.Slice(r.hashes, func(i, j int) bool { return r.hashes[i] < r.hashes[j] })
+}
+
+// 删除节点
+func (r *Ring) Remove(node string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	hashes := r.hashes[:0]
+	for _, h := range r.hashes {
+		if r.nodes[h] == node {
+			delete(r.nodes, h)
+			continue
+		}
+		hashes = append(hashes, h)
+	}
+	r.hashes = hashes
+}
+
+// 返回key的归属节点，环上没有节点时返回空字符串
+func (r *Ring) Get(key string) string {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	if len(r.hashes) == 0 {
+		return ""
+	}
+	h := r.hash([]byte(key))
+	idx := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= h })
+	if idx == len(r.hashes) {
+		// 超过最大的哈希值，回到环的起点
+		idx = 0
+	}
+	return r.nodes[r.hashes[idx]]
+}
+
+// 环上是否没有任何节点
+func (r *Ring) IsEmpty() bool {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return len(r.hashes) == 0
+}
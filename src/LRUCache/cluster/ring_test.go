@@ -0,0 +1,50 @@
+package cluster
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestRing(t *testing.T) {
+	// 用key本身的数字作为哈希值，方便推算归属节点
+	ring := NewRing(3, func(data []byte) uint32 {
+		n, _ := strconv.Atoi(string(data))
+		return uint32(n)
+	})
+	// 虚拟节点：2、12、22、4、14、24、6、16、26
+	ring.Add("6", "4", "2")
+
+	cases := map[string]string{"2": "2", "11": "2", "23": "4", "27": "2"}
+	for key, want := range cases {
+		if got := ring.Get(key); got != want {
+			t.Errorf("Get(%s) = %s, want %s", key, got, want)
+		}
+	}
+
+	// 增加节点8之后，27应该归属于8（虚拟节点28）
+	ring.Add("8")
+	if got := ring.Get("27"); got != "8" {
+		t.Errorf("Get(27) after Add(8) = %s, want 8", got)
+	}
+	ring.Remove("8")
+	if got := ring.Get("27"); got != "2" {
+		t.Errorf("Get(27) after Remove(8) = %s, want 2", got)
+	}
+}
+
+func TestRingDistribution(t *testing.T) {
+	ring := NewRing(50, nil)
+	if ring.Get("k") != "" || !ring.IsEmpty() {
+		t.Fatal("empty ring returned a node")
+	}
+	ring.Add("a", "b", "c")
+	counts := map[string]int{}
+	for i := 0; i < 30000; i++ {
+		counts[ring.Get("key-"+strconv.Itoa(i))]++
+	}
+	for node, n := range counts {
+		if n < 5000 || n > 15000 {
+			t.Errorf("node %s owns %d of 30000 keys, distribution too uneven", node, n)
+		}
+	}
+}
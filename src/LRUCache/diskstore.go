@@ -0,0 +1,349 @@
+package cache
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// 磁盘存储：只追加写的日志文件加上内存中的索引，作为缓存的第二层，存放从内存中淘汰的元素。
+// 每条记录的格式为：crc32(4字节) | 类型(1字节) | key长度(4字节) | value长度(4字节) | key | value，
+// crc32校验的是类型之后的所有内容。删除写入一条墓碑记录，被覆盖和删除的记录在压缩时清理掉。
+
+const (
+	diskLogName       = "cache.log"
+	diskHeaderSize    = 13
+	diskRecordPut     = byte(0)
+	diskRecordDelete  = byte(1)
+	defaultCompactMin = 1 << 20 // 无效数据超过1MB、并且超过有效数据时自动压缩
+)
+
+var (
+	ErrDiskStoreClosed  = errors.New("cache: disk store closed")
+	ErrDiskStoreCorrupt = errors.New("cache: disk store corrupt")
+)
+
+type DiskStore struct {
+	lock      sync.Mutex
+	dir       string
+	file      *os.File
+	size      int64                // 日志文件大小，也就是下一条记录的偏移量
+	index     map[string]diskIndex // key -> 最新的记录
+	liveBytes int64                // 有效记录的字节数
+	deadBytes int64                // 被覆盖、被删除的记录和墓碑的字节数
+
+	compactMin int64
+}
+
+// 一条记录在日志文件中的位置
+type diskIndex struct {
+	offset int64
+	keyLen uint32
+	valLen uint32
+}
+
+func (i diskIndex) size() int64 {
+	return diskHeaderSize + int64(i.keyLen) + int64(i.valLen)
+}
+
+// 打开dir目录下的磁盘存储，目录不存在时创建。
+// 打开时扫描整个日志文件重建索引，文件末尾写了一半或者校验失败的记录（比如进程在追加时被杀）会被截掉；
+// 这样的记录后面还有完整的记录时，说明是文件中间损坏了，返回ErrDiskStoreCorrupt。
+func OpenDiskStore(dir string) (*DiskStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &DiskStore{dir: dir, compactMin: defaultCompactMin}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// 写入key对应的数据，覆盖旧数据
+func (s *DiskStore) Put(key string, val []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.file == nil {
+		return ErrDiskStoreClosed
+	}
+	idx, err := s.append(diskRecordPut, key, val)
+	if err != nil {
+		return err
+	}
+	if old, exist := s.index[key]; exist {
+		s.liveBytes -= old.size()
+		s.deadBytes += old.size()
+	}
+	s.index[key] = idx
+	s.liveBytes += idx.size()
+	return s.maybeCompact()
+}
+
+// 读取key对应的数据，不存在时返回false
+func (s *DiskStore) Get(key string) ([]byte, bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.file == nil {
+		return nil, false, ErrDiskStoreClosed
+	}
+	idx, exist := s.index[key]
+	if !exist {
+		return nil, false, nil
+	}
+	val := make([]byte, idx.valLen)
+	if _, err := s.file.ReadAt(val, idx.offset+diskHeaderSize+int64(idx.keyLen)); err != nil {
+		return nil, false, err
+	}
+	return val, true, nil
+}
+
+// 删除key，key不存在时什么也不做
+func (s *DiskStore) Delete(key string) error {
+	_, err := s.remove(key)
+	return err
+}
+
+// 删除key，返回key删除前是否存在。只查索引，不读取数据
+func (s *DiskStore) remove(key string) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.file == nil {
+		return false, ErrDiskStoreClosed
+	}
+	old, exist := s.index[key]
+	if !exist {
+		return false, nil
+	}
+	tombstone, err := s.append(diskRecordDelete, key, nil)
+	if err != nil {
+		return false, err
+	}
+	delete(s.index, key)
+	s.liveBytes -= old.size()
+	s.deadBytes += old.size() + tombstone.size()
+	return true, s.maybeCompact()
+}
+
+// 删除所有数据
+func (s *DiskStore) Purge() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.file == nil {
+		return ErrDiskStoreClosed
+	}
+	if err := s.file.Truncate(0); err != nil {
+		return err
+	}
+	s.size, s.liveBytes, s.deadBytes = 0, 0, 0
+	s.index = make(map[string]diskIndex)
+	return nil
+}
+
+// 磁盘上的key个数
+func (s *DiskStore) Len() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.index)
+}
+
+// 日志文件的大小
+func (s *DiskStore) Size() int64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.size
+}
+
+// 压缩日志文件：只把有效记录写入新文件，再重命名覆盖旧文件
+func (s *DiskStore) Compact() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.file == nil {
+		return ErrDiskStoreClosed
+	}
+	return s.compact()
+}
+
+func (s *DiskStore) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+// 打开日志文件并重建索引
+func (s *DiskStore) open() error {
+	file, err := os.OpenFile(filepath.Join(s.dir, diskLogName), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	fileSize := info.Size()
+	s.file = file
+	s.index = make(map[string]diskIndex)
+	s.size, s.liveBytes, s.deadBytes = 0, 0, 0
+
+	header := make([]byte, diskHeaderSize)
+	for {
+		if _, err := file.ReadAt(header, s.size); err != nil {
+			break
+		}
+		idx := diskIndex{
+			offset: s.size,
+			keyLen: binary.BigEndian.Uint32(header[5:9]),
+			valLen: binary.BigEndian.Uint32(header[9:13]),
+		}
+		// 长度来自文件，先和文件大小比较再分配内存，损坏的长度不能让进程分配几个GB或者panic
+		var body []byte
+		valid := s.size+idx.size() <= fileSize
+		if valid {
+			body = make([]byte, int64(idx.keyLen)+int64(idx.valLen))
+			_, err := file.ReadAt(body, s.size+diskHeaderSize)
+			valid = err == nil && checkRecord(header, body)
+		}
+		if !valid {
+			// 写了一半的记录只会出现在末尾，后面还有完整的记录说明中间的记录损坏了，不能截掉后面的数据
+			if s.recordAfter(s.size+1, fileSize) {
+				_ = file.Close()
+				s.file = nil
+				return fmt.Errorf("%w: bad record at offset %d (key length %d, value length %d, file size %d) followed by valid records",
+					ErrDiskStoreCorrupt, s.size, idx.keyLen, idx.valLen, fileSize)
+			}
+			break
+		}
+
+		key := string(body[:idx.keyLen])
+		if old, exist := s.index[key]; exist {
+			s.liveBytes -= old.size()
+			s.deadBytes += old.size()
+		}
+		if header[4] == diskRecordDelete {
+			delete(s.index, key)
+			s.deadBytes += idx.size()
+		} else {
+			s.index[key] = idx
+			s.liveBytes += idx.size()
+		}
+		s.size += idx.size()
+	}
+	// 截掉末尾不完整或者校验失败的记录
+	if err := file.Truncate(s.size); err != nil {
+		_ = file.Close()
+		s.file = nil
+		return err
+	}
+	return nil
+}
+
+// 校验记录的类型和crc32
+func checkRecord(header, body []byte) bool {
+	if header[4] != diskRecordPut && header[4] != diskRecordDelete {
+		return false
+	}
+	crc := crc32.NewIEEE()
+	crc.Write(header[4:])
+	crc.Write(body)
+	return crc.Sum32() == binary.BigEndian.Uint32(header[0:4])
+}
+
+// 从offset开始到文件末尾，是否能在某个位置找到一条完整并且校验通过的记录
+func (s *DiskStore) recordAfter(offset, fileSize int64) bool {
+	buf := make([]byte, 64<<10)
+	for start := offset; start+diskHeaderSize <= fileSize; {
+		n, _ := s.file.ReadAt(buf, start)
+		if n < diskHeaderSize {
+			return false
+		}
+		for i := 0; i+diskHeaderSize <= n; i++ {
+			header := buf[i : i+diskHeaderSize]
+			bodyLen := int64(binary.BigEndian.Uint32(header[5:9])) + int64(binary.BigEndian.Uint32(header[9:13]))
+			at := start + int64(i)
+			if header[4] > diskRecordDelete || at+diskHeaderSize+bodyLen > fileSize {
+				continue
+			}
+			body := make([]byte, bodyLen)
+			if _, err := s.file.ReadAt(body, at+diskHeaderSize); err == nil && checkRecord(header, body) {
+				return true
+			}
+		}
+		start += int64(n - diskHeaderSize + 1)
+	}
+	return false
+}
+
+// 在日志文件末尾追加一条记录
+func (s *DiskStore) append(kind byte, key string, val []byte) (diskIndex, error) {
+	idx := diskIndex{offset: s.size, keyLen: uint32(len(key)), valLen: uint32(len(val))}
+	record := make([]byte, idx.size())
+	record[4] = kind
+	binary.BigEndian.PutUint32(record[5:9], idx.keyLen)
+	binary.BigEndian.PutUint32(record[9:13], idx.valLen)
+	copy(record[diskHeaderSize:], key)
+	copy(record[diskHeaderSize+len(key):], val)
+	binary.BigEndian.PutUint32(record[0:4], crc32.ChecksumIEEE(record[4:]))
+
+	if _, err := s.file.WriteAt(record, s.size); err != nil {
+		return diskIndex{}, err
+	}
+	s.size += idx.size()
+	return idx, nil
+}
+
+// 无效数据足够多时自动压缩
+func (s *DiskStore) maybeCompact() error {
+	if s.deadBytes < s.compactMin || s.deadBytes < s.liveBytes {
+		return nil
+	}
+	return s.compact()
+}
+
+func (s *DiskStore) compact() error {
+	path := filepath.Join(s.dir, diskLogName)
+	tmpPath := path + ".compact"
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	var offset int64
+	for _, idx := range s.index {
+		record := io.NewSectionReader(s.file, idx.offset, idx.size())
+		if _, err := io.Copy(io.NewOffsetWriter(tmp, offset), record); err != nil {
+			_ = tmp.Close()
+			_ = os.Remove(tmpPath)
+			return err
+		}
+		offset += idx.size()
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	_ = s.file.Close()
+	return s.open()
+}
@@ -0,0 +1,287 @@
+package cache
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDiskStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := OpenDiskStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Put("a", []byte("1"))
+	store.Put("b", []byte("2"))
+	store.Put("a", []byte("3"))
+	store.Delete("b")
+	if val, ok, _ := store.Get("a"); !ok || string(val) != "3" {
+		t.Fatalf("Get(a) = %q, %v", val, ok)
+	}
+	if _, ok, _ := store.Get("b"); ok {
+		t.Fatal("deleted key still readable")
+	}
+	store.Close()
+	if _, _, err := store.Get("a"); err != ErrDiskStoreClosed {
+		t.Fatalf("Get after Close err = %v", err)
+	}
+
+	// 模拟写了一半的记录，重新打开时应该被截掉
+	f, _ := os.OpenFile(filepath.Join(dir, diskLogName), os.O_WRONLY|os.O_APPEND, 0644)
+	f.Write([]byte{1, 2, 3, 4, 0, 0, 0})
+	f.Close()
+
+	store, err = OpenDiskStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if val, ok, _ := store.Get("a"); !ok || string(val) != "3" || store.Len() != 1 {
+		t.Fatalf("after reopen Get(a) = %q, %v, Len() = %d", val, ok, store.Len())
+	}
+
+	before := store.Size()
+	if err := store.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if store.Size() >= before {
+		t.Fatalf("Compact did not shrink log: %d -> %d", before, store.Size())
+	}
+	if val, ok, _ := store.Get("a"); !ok || string(val) != "3" {
+		t.Fatalf("after Compact Get(a) = %q, %v", val, ok)
+	}
+}
+
+func TestDiskStoreAutoCompact(t *testing.T) {
+	store, err := OpenDiskStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	store.compactMin = 1024
+
+	val := make([]byte, 100)
+	for i := 0; i < 100; i++ {
+		store.Put("same", val)
+	}
+	// 100条记录中只有1条有效，压缩之后日志不会超过两条记录的大小
+	if size := store.Size(); size > 2*(diskHeaderSize+4+100) {
+		t.Fatalf("log size %d, auto compaction did not run", size)
+	}
+}
+
+func TestCacheDiskTier(t *testing.T) {
+	store, err := OpenDiskStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	cache := NewCache(2, WithDiskTier(store))
+	for i := 0; i < 5; i++ {
+		cache.Put(strconv.Itoa(i), i)
+	}
+	if cache.Len() != 2 || store.Len() != 3 {
+		t.Fatalf("memory %d, disk %d, want 2 and 3", cache.Len(), store.Len())
+	}
+	// 0在磁盘上，Get时放回内存，同时把内存中最久未使用的3挤到磁盘上
+	if v := cache.Get("0"); v != 0 {
+		t.Fatalf("Get(0) = %v, want 0", v)
+	}
+	if !cache.Contains("0") || cache.Contains("3") {
+		t.Fatalf("memory keys = %v", cache.Keys())
+	}
+	if _, ok, _ := store.Get("0"); ok {
+		t.Fatal("promoted key still on disk")
+	}
+	for i := 0; i < 5; i++ {
+		if v := cache.Get(strconv.Itoa(i)); v != i {
+			t.Fatalf("Get(%d) = %v", i, v)
+		}
+	}
+
+	// 覆盖写入和删除都要处理磁盘上的旧值
+	cache.Put("1", "new")
+	if !cache.Delete("1") || cache.Get("1") != nil {
+		t.Fatal("deleted key still readable")
+	}
+	cache.PutWithTTL("ttl", "v", 10*time.Millisecond)
+	cache.Put("x", 1)
+	cache.Put("y", 2)
+	time.Sleep(20 * time.Millisecond)
+	if v := cache.Get("ttl"); v != nil {
+		t.Fatalf("expired entry loaded from disk: %v", v)
+	}
+
+	cache.Purge()
+	if store.Len() != 0 || cache.Get("2") != nil {
+		t.Fatal("Purge did not clear disk tier")
+	}
+}
+
+// 从磁盘层读回的元素算作命中，内存和磁盘上都没有才算未命中
+func TestCacheDiskTierStats(t *testing.T) {
+	store, err := OpenDiskStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	cache := NewCache(1, WithDiskTier(store))
+	cache.Put("a", 1)
+	cache.Put("b", 2) // a被挤到磁盘上
+	cache.Get("b")
+	cache.Get("a")
+	cache.Get("missing")
+	if stats := cache.Stats(); stats.Hits != 2 || stats.Misses != 1 {
+		t.Fatalf("hits %d, misses %d, want 2 and 1", stats.Hits, stats.Misses)
+	}
+}
+
+// 记录头中的长度被改坏时，打开返回错误，不能按坏掉的长度分配内存
+func TestDiskStoreCorruptLength(t *testing.T) {
+	dir := t.TempDir()
+	store, err := OpenDiskStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Put("a", []byte("1"))
+	store.Put("b", []byte("2"))
+	store.Close()
+
+	f, err := os.OpenFile(filepath.Join(dir, diskLogName), os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lengths [8]byte
+	binary.BigEndian.PutUint32(lengths[0:4], 0xffffffff)
+	binary.BigEndian.PutUint32(lengths[4:8], 0xffffffff)
+	f.WriteAt(lengths[:], 5)
+	f.Close()
+
+	if _, err := OpenDiskStore(dir); !errors.Is(err, ErrDiskStoreCorrupt) {
+		t.Fatalf("OpenDiskStore with corrupt lengths err = %v, want ErrDiskStoreCorrupt", err)
+	}
+}
+
+// 进程在追加记录时被杀，末尾留下记录头完整、数据不完整的记录，重新打开时截掉它
+func TestDiskStoreTornTail(t *testing.T) {
+	for _, cut := range []int64{1, 5} {
+		dir := t.TempDir()
+		store, err := OpenDiskStore(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		store.Put("a", []byte("1"))
+		tail := store.Size()
+		store.Put("b", []byte("value of b"))
+		size := store.Size()
+		store.Close()
+		if err := os.Truncate(filepath.Join(dir, diskLogName), size-cut); err != nil {
+			t.Fatal(err)
+		}
+
+		store, err = OpenDiskStore(dir)
+		if err != nil {
+			t.Fatalf("OpenDiskStore with the last record cut by %d bytes: %v", cut, err)
+		}
+		if _, ok, _ := store.Get("b"); ok || store.Len() != 1 || store.Size() != tail {
+			t.Fatalf("after reopen b found %v, Len() = %d, Size() = %d, want only a and size %d", ok, store.Len(), store.Size(), tail)
+		}
+		store.Put("c", []byte("3"))
+		store.Close()
+		store, err = OpenDiskStore(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if val, ok, _ := store.Get("c"); !ok || string(val) != "3" || store.Len() != 2 {
+			t.Fatalf("after append and reopen Get(c) = %q, %v, Len() = %d", val, ok, store.Len())
+		}
+		store.Close()
+	}
+}
+
+// 中间的记录校验失败、后面还有完整的记录时不能截掉，返回ErrDiskStoreCorrupt
+func TestDiskStoreCorruptMiddle(t *testing.T) {
+	dir := t.TempDir()
+	store, err := OpenDiskStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Put("a", []byte("1"))
+	store.Put("b", []byte("2"))
+	store.Close()
+
+	f, err := os.OpenFile(filepath.Join(dir, diskLogName), os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteAt([]byte("x"), diskHeaderSize+1) // 第一条记录的value
+	f.Close()
+
+	if _, err := OpenDiskStore(dir); !errors.Is(err, ErrDiskStoreCorrupt) {
+		t.Fatalf("OpenDiskStore with a bad record in the middle err = %v, want ErrDiskStoreCorrupt", err)
+	}
+}
+
+// 淘汰到磁盘的写入在释放缓存的锁之后执行，期间其它goroutine可以访问缓存
+func TestSpillOutsideLock(t *testing.T) {
+	lru := NewLRU[string, int](1)
+	var spilled []string
+	lru.spill = func(key string, value int, expireAt time.Time) func() {
+		return func() {
+			// 还持有锁的话这里会死锁
+			lru.Peek(key)
+			spilled = append(spilled, key)
+		}
+	}
+	lru.Put("a", 1)
+	lru.Put("b", 2)
+	if len(spilled) != 1 || spilled[0] != "a" {
+		t.Fatalf("spilled = %v, want [a]", spilled)
+	}
+}
+
+// 每个goroutine只读写自己的key，Get只能看到最后一次写入的值或者因为淘汰丢失的nil，
+// 不能从磁盘上读到被覆盖或者删除的旧值
+func TestCacheDiskTierConcurrent(t *testing.T) {
+	store, err := OpenDiskStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	cache := NewCache(8, WithDiskTier(store))
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				key := fmt.Sprintf("%d-%d", g, i%4)
+				switch i % 3 {
+				case 0, 1:
+					cache.Put(key, i)
+					if v := cache.Get(key); v != nil && v != i {
+						t.Errorf("Get(%s) after Put(%d) = %v", key, i, v)
+						return
+					}
+				case 2:
+					cache.Delete(key)
+					if v := cache.Get(key); v != nil {
+						t.Errorf("Get(%s) after Delete = %v", key, v)
+						return
+					}
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+}
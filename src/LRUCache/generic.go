@@ -0,0 +1,581 @@
+package cache
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 泛型LRU缓存，key和value的类型由类型参数指定，取值时不再需要类型断言。
+// Cache就是LRU[string, interface{}]的一层简单包装。
+
+type entry[K comparable, V any] struct {
+	Key      K
+	Value    V
+	expireAt time.Time // 过期时间，零值表示永不过期
+	cost     int64     // 元素的开销，只在按开销淘汰时使用
+	pre      *entry[K, V]
+	next     *entry[K, V]
+}
+
+// 元素是否已经过期
+func (e *entry[K, V]) expired(now time.Time) bool {
+	return !e.expireAt.IsZero() && now.After(e.expireAt)
+}
+
+type LRU[K comparable, V any] struct {
+	lock     sync.RWMutex // 每个缓存实例持有自己的锁，不同缓存之间互不竞争
+	cache    map[K]*entry[K, V]
+	capacity int  // 最多能放的元素个数，<=0表示不限制个数
+	noRoom   bool // 不能放任何元素，用于总容量比分段个数还小的分段缓存
+	head     *entry[K, V]
+	tail     *entry[K, V]
+
+	maxCost   int64 // 所有元素开销之和的上限，<=0表示不按开销淘汰
+	totalCost int64 // 当前所有元素的开销之和
+	sizer     Sizer // 计算元素开销的函数，为nil时每个元素的开销为1
+
+	ttl       time.Duration // 默认过期时间，Put时使用，0表示永不过期
+	stop      chan struct{} // 关闭后台清理goroutine和定期快照goroutine
+	closeOnce sync.Once
+
+	codec        Codec  // SaveTo和LoadFrom使用的编码方式
+	snapshotPath string // 定期快照的文件路径，为空表示不做快照
+
+	onEvict func(key K, value V, reason EvictReason) // 元素被移除时的回调
+	pending []evictedEntry[K, V]                     // 持有锁期间被移除、等待回调的元素
+
+	// 因为容量被淘汰的元素交给下一层存储：spill在持有锁时调用，只记下需要的状态，
+	// 返回的函数放进spills，释放锁之后再执行，磁盘读写不会阻塞其它访问缓存的goroutine
+	spill  func(key K, value V, expireAt time.Time) func()
+	spills []func()
+
+	// 判断过期用的当前时间，默认是time.Now，测试中替换成可以手动拨动的时钟
+	now func() time.Time
+
+	// 统计计数，用原子操作读写，Stats不需要加锁
+	hits        atomic.Uint64
+	misses      atomic.Uint64
+	evictions   atomic.Uint64
+	expirations atomic.Uint64
+}
+
+type evictedEntry[K comparable, V any] struct {
+	key    K
+	value  V
+	reason EvictReason
+}
+
+// 创建容量为cap的泛型LRU缓存，可选配置和NewCache相同
+func NewLRU[K comparable, V any](cap int, opts ...Option) *LRU[K, V] {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	lru := &LRU[K, V]{cache: make(map[K]*entry[K, V]), capacity: cap, ttl: o.ttl, maxCost: o.maxCost, sizer: o.sizer, codec: o.codec, now: time.Now}
+	if lru.codec == nil {
+		lru.codec = GobCodec
+	}
+	if o.janitorInterval > 0 || o.snapshotPath != "" {
+		lru.stop = make(chan struct{})
+	}
+	if o.janitorInterval > 0 {
+		go lru.janitor(o.janitorInterval)
+	}
+	if o.snapshotPath != "" {
+		lru.snapshotPath = o.snapshotPath
+		go lru.snapshotLoop(o.snapshotInterval)
+	}
+	return lru
+}
+
+// 把元素放入缓存中，如果缓存满了，则删除最近最少使用的那个元素，返回它的值和true。
+// 如果缓存没满，返回零值和false。key已经存在时更新它的值并提到队列头部。
+// 元素的过期时间为创建时通过WithTTL设置的默认过期时间。
+func (lru *LRU[K, V]) Put(key K, val V) (V, bool) {
+	return lru.PutWithTTL(key, val, lru.ttl)
+}
+
+// 和Put一样，但是单独指定该元素的过期时间，ttl<=0表示永不过期
+func (lru *LRU[K, V]) PutWithTTL(key K, val V, ttl time.Duration) (V, bool) {
+	cost := int64(1)
+	if lru.sizer != nil {
+		cost = lru.sizer(key, val)
+	}
+	return lru.put(key, val, ttl, cost)
+}
+
+// 和Put一样，但是直接指定该元素的开销，不使用WithSizer设置的开销计算函数。
+// 开启WithMaxCost之后，所有元素的开销之和超过上限时会从队列尾部开始淘汰，直到开销之和不超过上限。
+func (lru *LRU[K, V]) PutWithCost(key K, val V, cost int64) (V, bool) {
+	return lru.put(key, val, lru.ttl, cost)
+}
+
+// 放入元素，ttl<=0表示永不过期
+func (lru *LRU[K, V]) put(key K, val V, ttl time.Duration, cost int64) (V, bool) {
+	var expireAt time.Time
+	if ttl > 0 {
+		expireAt = lru.now().Add(ttl)
+	}
+	return lru.putAt(key, val, expireAt, cost)
+}
+
+// 放入元素并指定过期时刻，超过容量或者开销上限时从队列尾部开始淘汰。
+// 淘汰了多个元素时返回最先被淘汰的那个，所有被淘汰的元素都会触发OnEvict回调。
+func (lru *LRU[K, V]) putAt(key K, val V, expireAt time.Time, cost int64) (V, bool) {
+	lru.lock.Lock()
+	defer lru.unlockAndNotify()
+	return lru.set(key, val, expireAt, cost)
+}
+
+// key不存在（或者已经过期）并且ok返回true时才放入元素，返回是否放入了。ok在持有锁时调用
+func (lru *LRU[K, V]) putIfAbsent(key K, val V, expireAt time.Time, cost int64, ok func() bool) bool {
+	lru.lock.Lock()
+	defer lru.unlockAndNotify()
+
+	if existVal, exist := lru.cache[key]; exist && !existVal.expired(lru.now()) {
+		return false
+	}
+	if !ok() {
+		return false
+	}
+	lru.set(key, val, expireAt, cost)
+	return true
+}
+
+// putAt的实现，调用时必须持有写锁
+func (lru *LRU[K, V]) set(key K, val V, expireAt time.Time, cost int64) (V, bool) {
+	if existVal, exist := lru.cache[key]; exist {
+		if !existVal.expired(lru.now()) {
+			existVal.Value = val
+			existVal.expireAt = expireAt
+			lru.totalCost += cost - existVal.cost
+			existVal.cost = cost
+			lru.moveToHead(existVal)
+			return lru.evictOverflow()
+		}
+		// 已经过期的元素当作不存在，删掉后重新放入
+		lru.removeEntry(existVal, EvictExpired)
+	}
+
+	e := &entry[K, V]{Key: key, Value: val, expireAt: expireAt, cost: cost, next: lru.head}
+	if lru.head != nil {
+		lru.head.pre = e
+	}
+	lru.head = e
+	if lru.tail == nil {
+		lru.tail = e
+	}
+	lru.cache[key] = e
+	lru.totalCost += cost
+
+	return lru.evictOverflow()
+}
+
+// 元素个数或者开销之和超过上限时，从队列尾部开始淘汰，返回最先被淘汰的元素
+func (lru *LRU[K, V]) evictOverflow() (V, bool) {
+	var removed V
+	evicted := false
+	for lru.tail != nil && lru.overflow() {
+		removedEntry := lru.tail
+		lru.removeEntry(removedEntry, EvictCapacity)
+		if !evicted {
+			removed, evicted = removedEntry.Value, true
+		}
+	}
+	return removed, evicted
+}
+
+// 元素个数或者开销之和是否超过上限
+func (lru *LRU[K, V]) overflow() bool {
+	if lru.noRoom {
+		return len(lru.cache) > 0
+	}
+	if lru.capacity > 0 && len(lru.cache) > lru.capacity {
+		return true
+	}
+	return lru.maxCost > 0 && lru.totalCost > lru.maxCost
+}
+
+// 从缓存中获取元素，并把它提到队列头部。元素不存在或者已经过期时返回零值和false，过期元素会被删除。
+func (lru *LRU[K, V]) Get(key K) (V, bool) {
+	return lru.get(key, true)
+}
+
+// 同Get，countMiss为false时未命中不计数，由调用者查完下一层后再计数
+func (lru *LRU[K, V]) get(key K, countMiss bool) (V, bool) {
+	lru.lock.Lock()
+	defer lru.unlockAndNotify()
+
+	var zero V
+	if existVal, exist := lru.cache[key]; exist {
+		if existVal.expired(lru.now()) {
+			lru.removeEntry(existVal, EvictExpired)
+			if countMiss {
+				lru.misses.Add(1)
+			}
+			return zero, false
+		}
+		// 把该元素提到队列头部
+		lru.moveToHead(existVal)
+		lru.hits.Add(1)
+		return existVal.Value, true
+	}
+	if countMiss {
+		lru.misses.Add(1)
+	}
+	return zero, false
+}
+
+// 获取元素但不把它提到队列头部，不影响淘汰顺序
+func (lru *LRU[K, V]) Peek(key K) (V, bool) {
+	lru.lock.RLock()
+	defer lru.lock.RUnlock()
+
+	if existVal, exist := lru.cache[key]; exist && !existVal.expired(lru.now()) {
+		return existVal.Value, true
+	}
+	var zero V
+	return zero, false
+}
+
+// 返回元素的剩余过期时间，0表示永不过期。元素不存在或者已经过期时返回false
+func (lru *LRU[K, V]) TTL(key K) (time.Duration, bool) {
+	lru.lock.RLock()
+	defer lru.lock.RUnlock()
+
+	existVal, exist := lru.cache[key]
+	if !exist {
+		return 0, false
+	}
+	if existVal.expireAt.IsZero() {
+		return 0, true
+	}
+	ttl := existVal.expireAt.Sub(lru.now())
+	if ttl <= 0 {
+		return 0, false
+	}
+	return ttl, true
+}
+
+// 判断元素是否存在，不影响淘汰顺序
+func (lru *LRU[K, V]) Contains(key K) bool {
+	_, ok := lru.Peek(key)
+	return ok
+}
+
+// 缓存中的元素个数，包括已经过期但还没被删除的元素
+func (lru *LRU[K, V]) Len() int {
+	lru.lock.RLock()
+	defer lru.lock.RUnlock()
+	return len(lru.cache)
+}
+
+// 按从最近使用到最久未使用的顺序返回所有未过期元素的key
+func (lru *LRU[K, V]) Keys() []K {
+	lru.lock.RLock()
+	defer lru.lock.RUnlock()
+
+	now := lru.now()
+	keys := make([]K, 0, len(lru.cache))
+	for e := lru.head; e != nil; e = e.next {
+		if !e.expired(now) {
+			keys = append(keys, e.Key)
+		}
+	}
+	return keys
+}
+
+// 返回缓存统计信息的快照
+func (lru *LRU[K, V]) Stats() Stats {
+	lru.lock.RLock()
+	size, cost := len(lru.cache), lru.totalCost
+	lru.lock.RUnlock()
+
+	return Stats{
+		Hits:        lru.hits.Load(),
+		Misses:      lru.misses.Load(),
+		Evictions:   lru.evictions.Load(),
+		Expirations: lru.expirations.Load(),
+		Size:        size,
+		Cost:        cost,
+	}
+}
+
+// 当前所有元素的开销之和
+func (lru *LRU[K, V]) TotalCost() int64 {
+	lru.lock.RLock()
+	defer lru.lock.RUnlock()
+	return lru.totalCost
+}
+
+// 修改缓存容量，元素个数超过新容量时从队列尾部开始淘汰，返回被淘汰的元素个数
+func (lru *LRU[K, V]) Resize(newCap int) int {
+	return lru.resize(newCap, false)
+}
+
+// Resize的实现，noRoom为true时不能再放任何元素
+func (lru *LRU[K, V]) resize(newCap int, noRoom bool) int {
+	lru.lock.Lock()
+	defer lru.unlockAndNotify()
+
+	lru.capacity = newCap
+	lru.noRoom = noRoom
+	n := len(lru.cache)
+	lru.evictOverflow()
+	return n - len(lru.cache)
+}
+
+// 返回最久未使用的未过期元素，不影响淘汰顺序
+func (lru *LRU[K, V]) Oldest() (K, V, bool) {
+	lru.lock.RLock()
+	defer lru.lock.RUnlock()
+
+	now := lru.now()
+	for e := lru.tail; e != nil; e = e.pre {
+		if !e.expired(now) {
+			return e.Key, e.Value, true
+		}
+	}
+	var key K
+	var val V
+	return key, val, false
+}
+
+// 返回最近使用的未过期元素，不影响淘汰顺序
+func (lru *LRU[K, V]) Newest() (K, V, bool) {
+	lru.lock.RLock()
+	defer lru.lock.RUnlock()
+
+	now := lru.now()
+	for e := lru.head; e != nil; e = e.next {
+		if !e.expired(now) {
+			return e.Key, e.Value, true
+		}
+	}
+	var key K
+	var val V
+	return key, val, false
+}
+
+// 删除并返回最久未使用的未过期元素，触发EvictDeleted回调。
+// 途中遇到的过期元素会被一起删除，触发EvictExpired回调。
+func (lru *LRU[K, V]) RemoveOldest() (K, V, bool) {
+	lru.lock.Lock()
+	defer lru.unlockAndNotify()
+
+	now := lru.now()
+	for e := lru.tail; e != nil; e = lru.tail {
+		if e.expired(now) {
+			lru.removeEntry(e, EvictExpired)
+			continue
+		}
+		lru.removeEntry(e, EvictDeleted)
+		return e.Key, e.Value, true
+	}
+	var key K
+	var val V
+	return key, val, false
+}
+
+// 从最近使用到最久未使用依次对每个未过期元素调用fn，fn返回false时停止遍历。
+// 遍历期间持有读锁，fn中不能修改这个缓存（包括Get），否则会死锁；需要修改时请使用RangeSnapshot。
+func (lru *LRU[K, V]) Range(fn func(key K, value V) bool) {
+	lru.lock.RLock()
+	defer lru.lock.RUnlock()
+
+	now := lru.now()
+	for e := lru.head; e != nil; e = e.next {
+		if !e.expired(now) && !fn(e.Key, e.Value) {
+			return
+		}
+	}
+}
+
+// 和Range一样，但是从最久未使用到最近使用遍历
+func (lru *LRU[K, V]) RangeReverse(fn func(key K, value V) bool) {
+	lru.lock.RLock()
+	defer lru.lock.RUnlock()
+
+	now := lru.now()
+	for e := lru.tail; e != nil; e = e.pre {
+		if !e.expired(now) && !fn(e.Key, e.Value) {
+			return
+		}
+	}
+}
+
+// 和Range一样，但是先在锁内复制所有元素，再在锁外调用fn，fn中可以任意访问这个缓存。
+// fn看到的是调用时刻的快照，遍历期间的修改不会体现出来。
+func (lru *LRU[K, V]) RangeSnapshot(fn func(key K, value V) bool) {
+	for _, e := range lru.snapshot() {
+		if !fn(e.Key, e.Value) {
+			return
+		}
+	}
+}
+
+// 和RangeSnapshot一样，但是从最久未使用到最近使用遍历
+func (lru *LRU[K, V]) RangeReverseSnapshot(fn func(key K, value V) bool) {
+	entries := lru.snapshot()
+	for i := len(entries) - 1; i >= 0; i-- {
+		if !fn(entries[i].Key, entries[i].Value) {
+			return
+		}
+	}
+}
+
+// 按从最近使用到最久未使用的顺序复制所有未过期元素
+func (lru *LRU[K, V]) snapshot() []entry[K, V] {
+	lru.lock.RLock()
+	defer lru.lock.RUnlock()
+
+	now := lru.now()
+	entries := make([]entry[K, V], 0, len(lru.cache))
+	for e := lru.head; e != nil; e = e.next {
+		if !e.expired(now) {
+			entries = append(entries, entry[K, V]{Key: e.Key, Value: e.Value})
+		}
+	}
+	return entries
+}
+
+// 从缓存中删除元素，元素存在时返回true
+func (lru *LRU[K, V]) Delete(key K) bool {
+	lru.lock.Lock()
+	defer lru.unlockAndNotify()
+
+	existVal, exist := lru.cache[key]
+	if !exist {
+		return false
+	}
+	lru.removeEntry(existVal, EvictDeleted)
+	return true
+}
+
+// 清空缓存，每个元素都会触发一次EvictPurged回调
+func (lru *LRU[K, V]) Purge() {
+	lru.lock.Lock()
+	defer lru.unlockAndNotify()
+
+	for e := lru.tail; e != nil; e = lru.tail {
+		lru.removeEntry(e, EvictPurged)
+	}
+}
+
+// 设置元素被移除时的回调函数，容量淘汰、过期、Delete和Purge都会触发。
+// 回调在释放锁之后执行，所以回调里可以继续访问缓存。传nil取消回调。
+func (lru *LRU[K, V]) OnEvict(fn func(key K, value V, reason EvictReason)) {
+	lru.lock.Lock()
+	defer lru.lock.Unlock()
+	lru.onEvict = fn
+}
+
+// 停止后台清理goroutine和定期快照goroutine，可以重复调用。
+// 开启了定期快照时，关闭前会再保存一次快照，返回保存时的错误。
+func (lru *LRU[K, V]) Close() error {
+	var err error
+	lru.closeOnce.Do(func() {
+		if lru.stop != nil {
+			close(lru.stop)
+		}
+		if lru.snapshotPath != "" {
+			err = lru.SaveFile(lru.snapshotPath)
+		}
+	})
+	return err
+}
+
+// 后台清理goroutine，定期删除过期元素，直到Close被调用
+func (lru *LRU[K, V]) janitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			lru.deleteExpired()
+		case <-lru.stop:
+			return
+		}
+	}
+}
+
+// 从队列尾部到头部扫描，删除所有过期元素
+func (lru *LRU[K, V]) deleteExpired() {
+	lru.lock.Lock()
+	defer lru.unlockAndNotify()
+
+	now := lru.now()
+	for e := lru.tail; e != nil; {
+		pre := e.pre
+		if e.expired(now) {
+			lru.removeEntry(e, EvictExpired)
+		}
+		e = pre
+	}
+}
+
+// 把元素提到队列头部
+func (lru *LRU[K, V]) moveToHead(e *entry[K, V]) {
+	// 元素存在，下面把元素放到最前面去
+	if e == lru.head {
+		return
+	}
+	// 元素不是head，那么e.pre一定不为nil
+	e.pre.next = e.next
+	if e == lru.tail {
+		lru.tail = e.pre
+	} else {
+		e.next.pre = e.pre
+	}
+	e.pre = nil
+	e.next = lru.head
+	lru.head.pre = e
+	lru.head = e
+}
+
+// 把元素从队列和map中删除，设置了回调时记下被删除的元素，等释放锁之后再回调
+func (lru *LRU[K, V]) removeEntry(e *entry[K, V], reason EvictReason) {
+	if e.pre != nil {
+		e.pre.next = e.next
+	} else {
+		lru.head = e.next
+	}
+	if e.next != nil {
+		e.next.pre = e.pre
+	} else {
+		lru.tail = e.pre
+	}
+	e.pre = nil
+	e.next = nil
+	delete(lru.cache, e.Key)
+	lru.totalCost -= e.cost
+	switch reason {
+	case EvictCapacity:
+		lru.evictions.Add(1)
+		if lru.spill != nil {
+			if fn := lru.spill(e.Key, e.Value, e.expireAt); fn != nil {
+				lru.spills = append(lru.spills, fn)
+			}
+		}
+	case EvictExpired:
+		lru.expirations.Add(1)
+	}
+	if lru.onEvict != nil {
+		lru.pending = append(lru.pending, evictedEntry[K, V]{key: e.Key, value: e.Value, reason: reason})
+	}
+}
+
+// 释放写锁，然后把持有锁期间被淘汰的元素交给下一层存储，再对被移除的元素执行回调
+func (lru *LRU[K, V]) unlockAndNotify() {
+	pending, onEvict, spills := lru.pending, lru.onEvict, lru.spills
+	lru.pending, lru.spills = nil, nil
+	lru.lock.Unlock()
+
+	for _, spill := range spills {
+		spill()
+	}
+	for _, evicted := range pending {
+		onEvict(evicted.key, evicted.value, evicted.reason)
+	}
+}
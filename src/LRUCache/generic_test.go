@@ -0,0 +1,81 @@
+package cache
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestGenericLRU(t *testing.T) {
+	cache := NewLRU[int, string](2)
+	cache.Put(1, "one")
+	if v, ok := cache.Get(1); !ok || v != "one" {
+		t.Fatalf("Get(1) = %q, %v", v, ok)
+	}
+	cache.Put(2, "two")
+	cache.Get(1)
+	if removed, ok := cache.Put(3, "three"); !ok || removed != "two" {
+		t.Fatalf("Put(3) evicted %q, %v, want two", removed, ok)
+	}
+	if v, ok := cache.Get(2); ok || v != "" {
+		t.Fatalf("Get(2) = %q, %v, want zero value", v, ok)
+	}
+	if v, _ := cache.Get(3); v != "three" {
+		t.Fatalf("Get(3) = %q", v)
+	}
+	if v, _ := cache.Get(1); v != "one" {
+		t.Fatalf("Get(1) = %q", v)
+	}
+	if removed, ok := cache.Put(2, "two"); !ok || removed != "three" {
+		t.Fatalf("Put(2) evicted %q, %v, want three", removed, ok)
+	}
+	if got := fmt.Sprint(cache.Keys()); got != "[2 1]" {
+		t.Fatalf("Keys() = %s, want [2 1]", got)
+	}
+}
+
+func TestGenericTTL(t *testing.T) {
+	cache := NewLRU[string, int](10, WithTTL(20*time.Millisecond))
+	cache.Put("default", 1)
+	cache.PutWithTTL("forever", 2, 0)
+	time.Sleep(40 * time.Millisecond)
+	if _, ok := cache.Get("default"); ok {
+		t.Fatal("default entry should have expired")
+	}
+	if v, ok := cache.Get("forever"); !ok || v != 2 {
+		t.Fatalf("Get(forever) = %d, %v", v, ok)
+	}
+}
+
+func TestGenericOnEvict(t *testing.T) {
+	cache := NewLRU[string, int](1)
+	var keys []string
+	var values []int
+	cache.OnEvict(func(key string, value int, reason EvictReason) {
+		keys = append(keys, key+":"+reason.String())
+		values = append(values, value)
+	})
+	cache.Put("a", 1)
+	cache.Put("b", 2)
+	cache.Delete("b")
+	if got := fmt.Sprint(keys, values); got != "[a:capacity b:deleted] [1 2]" {
+		t.Fatalf("evicted = %s", got)
+	}
+}
+
+func Benchmark_GenericPut(t *testing.B) {
+	cache := NewLRU[int, int](600)
+	for i := 0; i < t.N; i++ {
+		cache.Put(i, i)
+	}
+}
+
+func Benchmark_GenericPutGet(t *testing.B) {
+	cache := NewLRU[string, int](600)
+	for i := 0; i < t.N; i++ {
+		cache.Put(strconv.Itoa(i), i)
+		cache.Get(strconv.Itoa(rand.Intn(2000)))
+	}
+}
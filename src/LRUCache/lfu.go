@@ -0,0 +1,137 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+)
+
+// LFU淘汰策略：缓存满了时淘汰访问次数最少的元素，访问次数相同时淘汰最久未使用的那个。
+// 每个访问次数对应一个链表，所有操作都是O(1)的。
+
+type lfuEntry struct {
+	key   string
+	value interface{}
+	freq  int
+	elem  *list.Element // 元素在freqs[freq]链表中的位置
+}
+
+type lfuCache struct {
+	lock     sync.Mutex
+	capacity int
+	cache    map[string]*lfuEntry
+	freqs    map[int]*list.List // 访问次数 -> 该访问次数的元素链表，链表头部是最近使用的
+	minFreq  int
+}
+
+func newLFU(capacity int) *lfuCache {
+	return &lfuCache{capacity: capacity, cache: make(map[string]*lfuEntry), freqs: make(map[int]*list.List)}
+}
+
+func (c *lfuCache) Put(key string, val interface{}) interface{} {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if e, exist := c.cache[key]; exist {
+		e.value = val
+		c.touch(e)
+		return nil
+	}
+
+	var removed interface{}
+	if len(c.cache) >= c.capacity {
+		// 淘汰访问次数最少的链表尾部元素
+		l := c.freqs[c.minFreq]
+		victim := l.Remove(l.Back()).(*lfuEntry)
+		if l.Len() == 0 {
+			delete(c.freqs, c.minFreq)
+		}
+		delete(c.cache, victim.key)
+		removed = victim.value
+	}
+
+	e := &lfuEntry{key: key, value: val, freq: 1}
+	e.elem = c.list(1).PushFront(e)
+	c.cache[key] = e
+	c.minFreq = 1
+	return removed
+}
+
+func (c *lfuCache) Get(key string) interface{} {
+	val, _ := c.GetOK(key)
+	return val
+}
+
+func (c *lfuCache) GetOK(key string) (interface{}, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	e, exist := c.cache[key]
+	if !exist {
+		return nil, false
+	}
+	c.touch(e)
+	return e.value, true
+}
+
+func (c *lfuCache) Contains(key string) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	_, exist := c.cache[key]
+	return exist
+}
+
+func (c *lfuCache) Delete(key string) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	e, exist := c.cache[key]
+	if !exist {
+		return false
+	}
+	c.unlink(e)
+	delete(c.cache, key)
+	return true
+}
+
+func (c *lfuCache) Len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return len(c.cache)
+}
+
+func (c *lfuCache) Purge() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.cache = make(map[string]*lfuEntry)
+	c.freqs = make(map[int]*list.List)
+	c.minFreq = 0
+}
+
+// 访问次数加1，把元素移到下一个访问次数的链表头部
+func (c *lfuCache) touch(e *lfuEntry) {
+	c.unlink(e)
+	e.freq++
+	e.elem = c.list(e.freq).PushFront(e)
+}
+
+// 把元素从它所在的访问次数链表中删除
+func (c *lfuCache) unlink(e *lfuEntry) {
+	l := c.freqs[e.freq]
+	l.Remove(e.elem)
+	if l.Len() == 0 {
+		delete(c.freqs, e.freq)
+		if c.minFreq == e.freq {
+			c.minFreq++
+		}
+	}
+}
+
+// 获取访问次数为freq的链表，不存在时创建
+func (c *lfuCache) list(freq int) *list.List {
+	l, exist := c.freqs[freq]
+	if !exist {
+		l = list.New()
+		c.freqs[freq] = l
+	}
+	return l
+}
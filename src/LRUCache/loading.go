@@ -0,0 +1,166 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// 自动加载的缓存：Get未命中时调用loader加载数据并放入缓存。
+// 同一个key同时只会调用一次loader，其它并发请求等待这次加载的结果，避免热点key过期时把后端打垮。
+
+// 加载key对应的数据
+type Loader func(ctx context.Context, key string) (interface{}, error)
+
+type LoadingCache struct {
+	cache        *Cache
+	loader       Loader
+	negativeTTL  time.Duration       // 加载失败时错误的缓存时间，0表示不缓存错误
+	refreshAhead time.Duration       // 元素剩余过期时间小于该值时异步刷新，0表示不提前刷新
+	errs         *LRU[string, error] // 缓存的加载错误，和正常的值分开放，Cache()中看不到
+	loads        FlightGroup
+	refreshes    FlightGroup // 提前刷新单独合并，未命中时的加载不会等待一个正在进行的刷新
+}
+
+// NewLoadingCache的可选配置
+type LoadingOption func(*LoadingCache)
+
+// 加载失败时把错误缓存ttl时间，期间对该key的请求直接返回这个错误，不再调用loader。
+// 错误单独缓存，最多缓存的个数和cache的容量相同，不会出现在Cache()中
+func WithNegativeTTL(ttl time.Duration) LoadingOption {
+	return func(lc *LoadingCache) {
+		lc.negativeTTL = ttl
+	}
+}
+
+// 命中的元素剩余过期时间小于d时，在后台异步调用loader刷新，当前请求直接返回旧值。
+// 刷新失败时保留旧值，等它自然过期。元素的过期时间由cache的WithTTL决定。
+func WithRefreshAhead(d time.Duration) LoadingOption {
+	return func(lc *LoadingCache) {
+		lc.refreshAhead = d
+	}
+}
+
+// 在cache之上创建自动加载的缓存，加载到的数据使用cache的默认过期时间
+func NewLoadingCache(cache *Cache, loader Loader, opts ...LoadingOption) *LoadingCache {
+	lc := &LoadingCache{cache: cache, loader: loader}
+	for _, opt := range opts {
+		opt(lc)
+	}
+	if lc.negativeTTL > 0 {
+		lc.errs = NewLRU[string, error](cache.lru.capacity)
+		lc.errs.now = cache.lru.now // 和cache用同一个时钟
+	}
+	return lc
+}
+
+// 获取key对应的数据，未命中时调用loader加载。
+// 多个goroutine同时加载同一个key时只调用一次loader，每个调用者都可以通过自己的ctx放弃等待。
+func (lc *LoadingCache) GetOrLoad(ctx context.Context, key string) (interface{}, error) {
+	if val, ok := lc.cache.GetOK(key); ok {
+		lc.maybeRefresh(key)
+		return val, nil
+	}
+	if lc.errs != nil {
+		if err, ok := lc.errs.Get(key); ok {
+			return nil, err
+		}
+	}
+
+	return lc.loads.Do(ctx, key, func() (interface{}, error) {
+		// 加载由多个调用者共享，不能因为第一个调用者取消了就让其它调用者也失败
+		return lc.load(context.WithoutCancel(ctx), key, false)
+	})
+}
+
+// 删除key和缓存的加载错误，下次GetOrLoad时重新加载
+func (lc *LoadingCache) Invalidate(key string) bool {
+	if lc.errs != nil {
+		lc.errs.Delete(key)
+	}
+	return lc.cache.Delete(key)
+}
+
+// 底层的缓存
+func (lc *LoadingCache) Cache() *Cache {
+	return lc.cache
+}
+
+// 调用loader加载数据并放入缓存。refresh为true表示是提前刷新，失败时不覆盖旧值
+func (lc *LoadingCache) load(ctx context.Context, key string, refresh bool) (interface{}, error) {
+	val, err := lc.loader(ctx, key)
+	if err != nil {
+		if !refresh && lc.errs != nil {
+			lc.errs.PutWithTTL(key, err, lc.negativeTTL)
+		}
+		return nil, err
+	}
+	lc.cache.Put(key, val)
+	if lc.errs != nil {
+		lc.errs.Delete(key)
+	}
+	return val, nil
+}
+
+// 元素快过期时在后台刷新，同一个key同时只有一个刷新
+func (lc *LoadingCache) maybeRefresh(key string) {
+	if lc.refreshAhead <= 0 {
+		return
+	}
+	ttl, ok := lc.cache.TTL(key)
+	if !ok || ttl == 0 || ttl > lc.refreshAhead {
+		return
+	}
+	lc.refreshes.do(key, func() (interface{}, error) {
+		return lc.load(context.Background(), key, true)
+	})
+}
+
+// 合并对同一个key的并发调用，同一时刻每个key只有一个调用在执行。零值可以直接使用
+type FlightGroup struct {
+	lock  sync.Mutex
+	calls map[string]*flightCall
+}
+
+type flightCall struct {
+	done chan struct{} // 调用结束后关闭
+	val  interface{}
+	err  error
+}
+
+// 执行fn或者复用key正在执行的调用，等待它的结果。fn在新的goroutine中执行，
+// 调用者可以通过ctx放弃等待，fn不会因此停止，需要ctx时应该用context.WithoutCancel传给fn。
+func (g *FlightGroup) Do(ctx context.Context, key string, fn func() (interface{}, error)) (interface{}, error) {
+	c := g.do(key, fn)
+	select {
+	case <-c.done:
+		return c.val, c.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// 如果key没有正在执行的调用，就在新的goroutine中执行fn；否则复用正在执行的调用。
+// 返回的flightCall在调用结束后关闭done。
+func (g *FlightGroup) do(key string, fn func() (interface{}, error)) *flightCall {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if c, exist := g.calls[key]; exist {
+		return c
+	}
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall)
+	}
+	c := &flightCall{done: make(chan struct{})}
+	g.calls[key] = c
+
+	go func() {
+		c.val, c.err = fn()
+		g.lock.Lock()
+		delete(g.calls, key)
+		g.lock.Unlock()
+		close(c.done)
+	}()
+	return c
+}
@@ -0,0 +1,214 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 手动拨动的时钟，替换缓存中的time.Now，测试过期和提前刷新时不依赖sleep的时间窗口
+type fakeClock struct {
+	nanos atomic.Int64
+}
+
+func newFakeClock() *fakeClock {
+	c := &fakeClock{}
+	c.nanos.Store(time.Now().UnixNano())
+	return c
+}
+
+func (c *fakeClock) Now() time.Time {
+	return time.Unix(0, c.nanos.Load())
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.nanos.Add(int64(d))
+}
+
+// 使用clock的缓存
+func newClockCache(clock *fakeClock, capacity int, opts ...Option) *Cache {
+	cache := NewCache(capacity, opts...)
+	cache.lru.now = clock.Now
+	return cache
+}
+
+// key正在执行的调用，没有时返回nil
+func inFlight(g *FlightGroup, key string) *flightCall {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return g.calls[key]
+}
+
+func TestLoadingCacheSingleflight(t *testing.T) {
+	var calls atomic.Int32
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	lc := NewLoadingCache(NewCache(10), func(ctx context.Context, key string) (interface{}, error) {
+		calls.Add(1)
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-release
+		return "value-" + key, nil
+	})
+
+	var wg sync.WaitGroup
+	results := make([]interface{}, 50)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _ = lc.GetOrLoad(context.Background(), "hot")
+		}(i)
+	}
+	// 加载开始之后到来的请求等待这次加载，加载结束之后到来的请求命中缓存，都不会再调用loader
+	<-started
+	close(release)
+	wg.Wait()
+
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("loader called %d times, want 1", n)
+	}
+	for i, v := range results {
+		if v != "value-hot" {
+			t.Fatalf("result %d = %v", i, v)
+		}
+	}
+	if v, err := lc.GetOrLoad(context.Background(), "hot"); v != "value-hot" || err != nil || calls.Load() != 1 {
+		t.Fatalf("cached GetOrLoad = %v, %v, calls %d", v, err, calls.Load())
+	}
+}
+
+func TestLoadingCacheNegativeTTL(t *testing.T) {
+	var calls atomic.Int32
+	errBackend := errors.New("backend down")
+	clock := newFakeClock()
+	lc := NewLoadingCache(newClockCache(clock, 10), func(ctx context.Context, key string) (interface{}, error) {
+		calls.Add(1)
+		return nil, errBackend
+	}, WithNegativeTTL(time.Minute))
+
+	for i := 0; i < 3; i++ {
+		if _, err := lc.GetOrLoad(context.Background(), "k"); err != errBackend {
+			t.Fatalf("err = %v, want %v", err, errBackend)
+		}
+	}
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("loader called %d times within negative TTL, want 1", n)
+	}
+	// 错误不放在底层的缓存中
+	if v, ok := lc.Cache().GetOK("k"); ok || lc.Cache().Len() != 0 {
+		t.Fatalf("cached error visible through Cache(): %v, len %d", v, lc.Cache().Len())
+	}
+	clock.Advance(time.Minute)
+	lc.GetOrLoad(context.Background(), "k")
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("loader called %d times at the end of negative TTL, want 1", n)
+	}
+	clock.Advance(time.Millisecond)
+	lc.GetOrLoad(context.Background(), "k")
+	if n := calls.Load(); n != 2 {
+		t.Fatalf("loader called %d times after negative TTL, want 2", n)
+	}
+	// Invalidate同时删除缓存的错误
+	lc.Invalidate("k")
+	lc.GetOrLoad(context.Background(), "k")
+	if n := calls.Load(); n != 3 {
+		t.Fatalf("loader called %d times after Invalidate, want 3", n)
+	}
+}
+
+func TestLoadingCacheContextCancel(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	lc := NewLoadingCache(NewCache(10), func(ctx context.Context, key string) (interface{}, error) {
+		<-release
+		return 1, nil
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := lc.GetOrLoad(ctx, "slow"); err != context.DeadlineExceeded {
+		t.Fatalf("err = %v, want deadline exceeded", err)
+	}
+}
+
+func TestLoadingCacheRefreshAhead(t *testing.T) {
+	var version atomic.Int32
+	release := make(chan struct{})
+	clock := newFakeClock()
+	lc := NewLoadingCache(newClockCache(clock, 10, WithTTL(100*time.Millisecond)), func(ctx context.Context, key string) (interface{}, error) {
+		v := version.Add(1)
+		if v > 1 {
+			<-release
+		}
+		return v, nil
+	}, WithRefreshAhead(80*time.Millisecond))
+
+	if v, _ := lc.GetOrLoad(context.Background(), "k"); v != int32(1) {
+		t.Fatalf("first load = %v, want 1", v)
+	}
+	// 剩余时间还有90ms，不刷新
+	clock.Advance(10 * time.Millisecond)
+	lc.GetOrLoad(context.Background(), "k")
+	if inFlight(&lc.refreshes, "k") != nil {
+		t.Fatal("refreshed while the ttl left is longer than 80ms")
+	}
+
+	// 剩余时间小于80ms，返回旧值并在后台刷新
+	clock.Advance(30 * time.Millisecond)
+	if v, _ := lc.GetOrLoad(context.Background(), "k"); v != int32(1) {
+		t.Fatalf("stale read = %v, want 1", v)
+	}
+	refresh := inFlight(&lc.refreshes, "k")
+	if refresh == nil {
+		t.Fatal("no refresh started when the ttl left is shorter than 80ms")
+	}
+	// 刷新完成之前仍然返回旧值，不会再开始一个刷新
+	if v, _ := lc.GetOrLoad(context.Background(), "k"); v != int32(1) || inFlight(&lc.refreshes, "k") != refresh {
+		t.Fatalf("read during refresh = %v, want 1 from the same refresh", v)
+	}
+	close(release)
+	<-refresh.done
+	if v, _ := lc.Cache().Peek("k"); v != int32(2) || version.Load() != 2 {
+		t.Fatalf("refreshed value = %v, loader called %d times, want 2", v, version.Load())
+	}
+}
+
+// 元素在刷新过程中过期时，未命中的请求自己加载，不等待刷新，也不会拿到刷新的错误
+func TestLoadingCacheRefreshOwnFlight(t *testing.T) {
+	var calls atomic.Int32
+	refreshing := make(chan struct{})
+	release := make(chan struct{})
+	errBackend := errors.New("backend down")
+	clock := newFakeClock()
+	lc := NewLoadingCache(newClockCache(clock, 10, WithTTL(40*time.Millisecond)), func(ctx context.Context, key string) (interface{}, error) {
+		switch calls.Add(1) {
+		case 2: // 提前刷新，一直卡到测试结束，然后失败
+			close(refreshing)
+			<-release
+			return nil, errBackend
+		default:
+			return "value", nil
+		}
+	}, WithRefreshAhead(30*time.Millisecond), WithNegativeTTL(time.Minute))
+	defer close(release)
+
+	lc.GetOrLoad(context.Background(), "k")
+	clock.Advance(20 * time.Millisecond)
+	lc.GetOrLoad(context.Background(), "k") // 触发刷新
+	<-refreshing
+	clock.Advance(30 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if v, err := lc.GetOrLoad(ctx, "k"); v != "value" || err != nil {
+		t.Fatalf("GetOrLoad during a stuck refresh = %v, %v, want value", v, err)
+	}
+	if n := calls.Load(); n != 3 {
+		t.Fatalf("loader called %d times, want 3", n)
+	}
+}
@@ -0,0 +1,302 @@
+package cache
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 最朴素的LRU实现，用来和Cache对照：keys按从最近使用到最久未使用排列
+type modelLRU struct {
+	capacity int
+	keys     []string
+	values   map[string]interface{}
+}
+
+func newModelLRU(capacity int) *modelLRU {
+	return &modelLRU{capacity: capacity, values: make(map[string]interface{})}
+}
+
+func (m *modelLRU) index(key string) int {
+	for i, k := range m.keys {
+		if k == key {
+			return i
+		}
+	}
+	return -1
+}
+
+func (m *modelLRU) touch(key string) {
+	i := m.index(key)
+	m.keys = append(m.keys[:i], m.keys[i+1:]...)
+	m.keys = append([]string{key}, m.keys...)
+}
+
+func (m *modelLRU) Put(key string, val interface{}) interface{} {
+	if _, exist := m.values[key]; exist {
+		m.values[key] = val
+		m.touch(key)
+		return nil
+	}
+	m.values[key] = val
+	m.keys = append([]string{key}, m.keys...)
+	return m.evict()
+}
+
+// 超出容量时淘汰最久未使用的元素，返回最后一个被淘汰的值
+func (m *modelLRU) evict() interface{} {
+	var removed interface{}
+	for m.capacity > 0 && len(m.keys) > m.capacity {
+		oldest := m.keys[len(m.keys)-1]
+		removed = m.values[oldest]
+		m.keys = m.keys[:len(m.keys)-1]
+		delete(m.values, oldest)
+	}
+	return removed
+}
+
+func (m *modelLRU) Get(key string) (interface{}, bool) {
+	val, exist := m.values[key]
+	if exist {
+		m.touch(key)
+	}
+	return val, exist
+}
+
+func (m *modelLRU) Peek(key string) (interface{}, bool) {
+	val, exist := m.values[key]
+	return val, exist
+}
+
+func (m *modelLRU) Delete(key string) bool {
+	i := m.index(key)
+	if i < 0 {
+		return false
+	}
+	m.keys = append(m.keys[:i], m.keys[i+1:]...)
+	delete(m.values, key)
+	return true
+}
+
+func (m *modelLRU) RemoveOldest() (string, interface{}, bool) {
+	if len(m.keys) == 0 {
+		return "", nil, false
+	}
+	key := m.keys[len(m.keys)-1]
+	val := m.values[key]
+	m.Delete(key)
+	return key, val, true
+}
+
+func (m *modelLRU) Resize(capacity int) int {
+	n := len(m.keys)
+	m.capacity = capacity
+	m.evict()
+	return n - len(m.keys)
+}
+
+// 按ops描述的操作序列同时操作Cache和modelLRU，每一步都比较返回值和元素顺序。
+// ops中每两个字节表示一个操作：第一个字节选择操作，第二个字节选择key。
+func checkAgainstModel(t *testing.T, capacity int, ops []byte) {
+	t.Helper()
+	cache := NewCache(capacity)
+	model := newModelLRU(capacity)
+
+	for i := 0; i+1 < len(ops); i += 2 {
+		key := strconv.Itoa(int(ops[i+1] % 16))
+		var got, want string
+		switch ops[i] % 7 {
+		case 0, 1:
+			op := fmt.Sprintf("Put(%s, %d)", key, i)
+			got = op + fmt.Sprint(cache.Put(key, i))
+			want = op + fmt.Sprint(model.Put(key, i))
+		case 2, 3:
+			v, ok := cache.GetOK(key)
+			got = fmt.Sprint("Get(", key, ")", v, ok)
+			v, ok = model.Get(key)
+			want = fmt.Sprint("Get(", key, ")", v, ok)
+		case 4:
+			v, ok := cache.Peek(key)
+			got = fmt.Sprint("Peek(", key, ")", v, ok)
+			v, ok = model.Peek(key)
+			want = fmt.Sprint("Peek(", key, ")", v, ok)
+		case 5:
+			got = fmt.Sprint("Delete(", key, ")", cache.Delete(key))
+			want = fmt.Sprint("Delete(", key, ")", model.Delete(key))
+		case 6:
+			if ops[i+1]%8 == 0 {
+				n := int(ops[i+1]/8) % 10
+				got = fmt.Sprint("Resize(", n, ")", cache.Resize(n))
+				want = fmt.Sprint("Resize(", n, ")", model.Resize(n))
+			} else {
+				k, v, ok := cache.RemoveOldest()
+				got = fmt.Sprint("RemoveOldest()", k, v, ok)
+				k, v, ok = model.RemoveOldest()
+				want = fmt.Sprint("RemoveOldest()", k, v, ok)
+			}
+		}
+		if got != want {
+			t.Fatalf("step %d: got %s, want %s", i/2, got, want)
+		}
+		if got, want := fmt.Sprint(cache.Keys()), fmt.Sprint(model.keys); got != want {
+			t.Fatalf("step %d: Keys() = %s, want %s", i/2, got, want)
+		}
+		if cache.Len() != len(model.keys) {
+			t.Fatalf("step %d: Len() = %d, want %d", i/2, cache.Len(), len(model.keys))
+		}
+	}
+}
+
+func TestAgainstModel(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for round := 0; round < 200; round++ {
+		ops := make([]byte, 400)
+		r.Read(ops)
+		checkAgainstModel(t, 1+r.Intn(8), ops)
+	}
+}
+
+func FuzzAgainstModel(f *testing.F) {
+	f.Add(uint8(2), []byte{0, 1, 0, 2, 2, 1, 0, 3, 6, 1, 6, 8})
+	f.Add(uint8(0), []byte{0, 1, 0, 2, 5, 1, 6, 0})
+	f.Fuzz(func(t *testing.T, capacity uint8, ops []byte) {
+		checkAgainstModel(t, int(capacity%10), ops)
+	})
+}
+
+// 任意输入都只能返回错误，不能panic或者按输入中的数字分配大量内存
+func FuzzLoadFrom(f *testing.F) {
+	for _, codec := range []Codec{GobCodec, JSONCodec} {
+		cache := NewCache(10, WithCodec(codec))
+		cache.Put("k", "v")
+		cache.PutWithTTL("t", 1, time.Hour)
+		var buf bytes.Buffer
+		if err := cache.SaveTo(&buf); err != nil {
+			f.Fatal(err)
+		}
+		f.Add(buf.Bytes())
+	}
+	f.Add([]byte(`{"Version":1,"Count":-1}`))
+	f.Add([]byte(`{"Version":1,"Count":1000000000000}`))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, codec := range []Codec{GobCodec, JSONCodec} {
+			cache := NewCache(10, WithCodec(codec))
+			if err := cache.LoadFrom(bytes.NewReader(data)); err == nil && cache.Len() > 10 {
+				t.Fatalf("Len() = %d after LoadFrom, want <= 10", cache.Len())
+			}
+		}
+	})
+}
+
+func FuzzReadTrace(f *testing.F) {
+	f.Add("# comment\na\n\nb\n a \n")
+	f.Fuzz(func(t *testing.T, data string) {
+		trace, err := ReadTrace(bytes.NewBufferString(data))
+		if err != nil {
+			return
+		}
+		for _, key := range trace {
+			if key == "" {
+				t.Fatal("ReadTrace returned an empty key")
+			}
+		}
+	})
+}
+
+// 多个goroutine同时执行各种操作，配合-race运行检查数据竞争，结束后检查内部状态是否一致
+func TestConcurrentStress(t *testing.T) {
+	caches := map[string]Policy{
+		"LRU":     NewCache(64, WithTTL(time.Millisecond), WithJanitor(time.Millisecond)),
+		"Sharded": NewShardedCache(64, 4, WithMaxCost(1000)),
+		"CLOCK":   NewClockCache(64),
+	}
+	// 加上前缀，不能覆盖上面手动创建的LRU和CLOCK
+	for _, policy := range Policies {
+		caches["policy-"+policy.String()] = NewPolicy(policy, 64)
+	}
+	for name, cache := range caches {
+		// 停掉LRU的后台清理goroutine
+		if c, ok := cache.(interface{ Close() error }); ok {
+			defer c.Close()
+		}
+		t.Run(name, func(t *testing.T) {
+			stress(t, cache)
+			if n := cache.Len(); n > 64 {
+				t.Fatalf("Len() = %d, want <= 64", n)
+			}
+		})
+	}
+}
+
+func stress(t *testing.T, cache Policy) {
+	// 回调在锁外执行，同样会和其它操作并发
+	if c, ok := cache.(interface {
+		OnEvict(func(key string, value interface{}, reason EvictReason))
+	}); ok {
+		c.OnEvict(func(key string, value interface{}, reason EvictReason) {
+			if _, isInt := value.(int); !isInt {
+				t.Errorf("OnEvict(%s) value = %v, not a value that was put", key, value)
+			}
+		})
+	}
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(seed))
+			for i := 0; i < 2000; i++ {
+				key := strconv.Itoa(r.Intn(128))
+				switch r.Intn(10) {
+				case 0:
+					cache.Delete(key)
+				case 1:
+					cache.Contains(key)
+				case 2:
+					cache.Len()
+				case 3, 4, 5:
+					cache.Put(key, i)
+				default:
+					if v, ok := cache.GetOK(key); ok {
+						if _, isInt := v.(int); !isInt {
+							t.Errorf("Get(%s) = %v, not a value that was put", key, v)
+						}
+					}
+				}
+			}
+		}(int64(g))
+	}
+
+	// 同时遍历和查看统计信息
+	if c, ok := cache.(*Cache); ok {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				c.Range(func(key string, v interface{}) bool { return true })
+				c.RangeSnapshot(func(key string, v interface{}) bool {
+					c.Get(key)
+					return true
+				})
+				c.Stats()
+				c.Keys()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if c, ok := cache.(*Cache); ok {
+		if keys := c.Keys(); len(keys) > c.Len() {
+			t.Fatalf("len(Keys()) = %d > Len() = %d", len(keys), c.Len())
+		}
+	}
+	if c, ok := cache.(io.Closer); ok {
+		c.Close()
+	}
+}
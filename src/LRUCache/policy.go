@@ -0,0 +1,81 @@
+package cache
+
+// 淘汰策略：LRU在扫描类的访问模式下命中率很差（一次全表扫描就会把热点数据全部挤出去），
+// 所以这里提供多种淘汰策略，创建缓存时通过NewPolicy选择。
+// *Cache、*ShardedCache和*ClockCache都实现了Policy接口。
+
+type Policy interface {
+	// 放入元素，缓存满了时返回被淘汰的元素，否则返回nil
+	Put(key string, val interface{}) interface{}
+	// 获取元素，不存在时返回nil
+	Get(key string) interface{}
+	// 获取元素，并返回元素是否存在
+	GetOK(key string) (interface{}, bool)
+	// 判断元素是否存在，不影响淘汰顺序
+	Contains(key string) bool
+	// 删除元素，元素存在时返回true
+	Delete(key string) bool
+	// 缓存中的元素个数
+	Len() int
+	// 清空缓存
+	Purge()
+}
+
+var (
+	_ Policy = (*Cache)(nil)
+	_ Policy = (*ShardedCache)(nil)
+	_ Policy = (*ClockCache)(nil)
+)
+
+// 淘汰策略类型
+type PolicyType int
+
+const (
+	PolicyLRU     PolicyType = iota // 最近最少使用
+	PolicyLFU                       // 最不经常使用
+	Policy2Q                        // 2Q，新元素先进入FIFO队列，再次访问才进入LRU队列
+	PolicyARC                       // 自适应替换缓存，在最近使用和经常使用之间自动调整
+	PolicyTinyLFU                   // W-TinyLFU，窗口LRU加上基于频率估计的准入策略
+	PolicyClock                     // CLOCK，近似LRU，Get只需要读锁
+)
+
+// 所有淘汰策略，按PolicyType的顺序排列
+var Policies = []PolicyType{PolicyLRU, PolicyLFU, Policy2Q, PolicyARC, PolicyTinyLFU, PolicyClock}
+
+func (p PolicyType) String() string {
+	switch p {
+	case PolicyLRU:
+		return "LRU"
+	case PolicyLFU:
+		return "LFU"
+	case Policy2Q:
+		return "2Q"
+	case PolicyARC:
+		return "ARC"
+	case PolicyTinyLFU:
+		return "W-TinyLFU"
+	case PolicyClock:
+		return "CLOCK"
+	}
+	return "unknown"
+}
+
+// 创建容量为capacity、使用指定淘汰策略的缓存
+func NewPolicy(policy PolicyType, capacity int) Policy {
+	if capacity <= 0 {
+		capacity = 1
+	}
+	switch policy {
+	case PolicyLFU:
+		return newLFU(capacity)
+	case Policy2Q:
+		return newTwoQueue(capacity)
+	case PolicyARC:
+		return newARC(capacity)
+	case PolicyTinyLFU:
+		return newTinyLFU(capacity)
+	case PolicyClock:
+		return NewClockCache(capacity)
+	}
+	return NewCache(capacity)
+}
@@ -0,0 +1,123 @@
+package cache
+
+import (
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestPolicies(t *testing.T) {
+	for _, policy := range Policies {
+		t.Run(policy.String(), func(t *testing.T) {
+			cache := NewPolicy(policy, 100)
+			for i := 0; i < 1000; i++ {
+				cache.Put(strconv.Itoa(i), i)
+				if cache.Len() > 100 {
+					t.Fatalf("Len() = %d after %d puts, want <= 100", cache.Len(), i+1)
+				}
+			}
+			cache.Put("k", "v")
+			if v, ok := cache.GetOK("k"); !ok || v != "v" {
+				t.Fatalf("GetOK(k) = %v, %v", v, ok)
+			}
+			cache.Put("k", "v2")
+			if v := cache.Get("k"); v != "v2" {
+				t.Fatalf("Get(k) after update = %v, want v2", v)
+			}
+			if !cache.Contains("k") || !cache.Delete("k") || cache.Contains("k") {
+				t.Fatal("Contains/Delete reported wrong result")
+			}
+			cache.Purge()
+			if cache.Len() != 0 {
+				t.Fatalf("Len() after Purge = %d", cache.Len())
+			}
+		})
+	}
+}
+
+func TestLFUEvictsLeastFrequent(t *testing.T) {
+	cache := NewPolicy(PolicyLFU, 2)
+	cache.Put("a", 1)
+	cache.Put("b", 2)
+	cache.Get("a")
+	if removed := cache.Put("c", 3); removed != 2 {
+		t.Fatalf("Put(c) evicted %v, want 2", removed)
+	}
+}
+
+// 热点数据被一次性扫描打断时，LRU会丢掉热点数据，其它策略应该有更高的命中率
+func TestScanResistance(t *testing.T) {
+	trace := loopWithScanTrace(100, 1000, 20)
+	results := CompareTrace(200, trace)
+	lru := results[0]
+	for _, result := range results[1:] {
+		t.Logf("%s hit ratio %.3f", result.Policy, result.HitRatio())
+		// LFU和CLOCK本身不抗扫描
+		if result.Policy != PolicyLFU && result.Policy != PolicyClock && result.HitRatio() <= lru.HitRatio() {
+			t.Errorf("%s hit ratio %.3f <= LRU %.3f", result.Policy, result.HitRatio(), lru.HitRatio())
+		}
+	}
+	t.Logf("LRU hit ratio %.3f", lru.HitRatio())
+}
+
+func TestReadTrace(t *testing.T) {
+	trace, err := ReadTrace(strings.NewReader("# comment\na\n\nb\n a \n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(trace, ",") != "a,b,a" {
+		t.Fatalf("ReadTrace = %v", trace)
+	}
+}
+
+func BenchmarkTraceZipf(b *testing.B) {
+	benchmarkTrace(b, zipfTrace(100000, 10000))
+}
+
+func BenchmarkTraceScan(b *testing.B) {
+	benchmarkTrace(b, loopWithScanTrace(500, 10000, 20))
+}
+
+// 每个淘汰策略回放一遍访问轨迹，把命中率作为指标报告出来
+func benchmarkTrace(b *testing.B, trace []string) {
+	for _, policy := range Policies {
+		b.Run(policy.String(), func(b *testing.B) {
+			var result TraceResult
+			for i := 0; i < b.N; i++ {
+				result = ReplayTrace(NewPolicy(policy, 1000), trace)
+			}
+			b.ReportMetric(result.HitRatio()*100, "hit%")
+		})
+	}
+}
+
+// 服从zipf分布的访问轨迹，少数key被频繁访问
+func zipfTrace(n int, keys uint64) []string {
+	r := rand.New(rand.NewSource(1))
+	zipf := rand.NewZipf(r, 1.1, 1, keys-1)
+	trace := make([]string, n)
+	for i := range trace {
+		trace[i] = strconv.FormatUint(zipf.Uint64(), 10)
+	}
+	return trace
+}
+
+// 反复访问hot个热点key，每轮之间插入一段scan个只访问一次的key
+func loopWithScanTrace(hot, scan, rounds int) []string {
+	var trace []string
+	next := hot
+	for round := 0; round < rounds; round++ {
+		for i := 0; i < hot; i++ {
+			trace = append(trace, strconv.Itoa(i))
+		}
+		for i := 0; i < hot; i++ {
+			trace = append(trace, strconv.Itoa(i))
+		}
+		for i := 0; i < scan; i++ {
+			trace = append(trace, "scan-"+strconv.Itoa(next))
+			next++
+		}
+	}
+	return trace
+}
@@ -0,0 +1,193 @@
+package cache
+
+import "time"
+
+// 分段LRU缓存：按key的哈希值把元素分散到多个相互独立的LRU分段中，
+// 每个分段有自己的锁，多核并发读写时不会全部竞争同一把锁。
+
+type ShardedCache struct {
+	shards []*Cache
+}
+
+// 创建分段缓存，capacity为总容量，capacity<=0表示不限制元素个数，shardNum为分段个数。
+// 总容量拆分到每个分段上，各分段的容量之和正好是capacity，所以Len()不会超过capacity；
+// 但每个分段只能淘汰自己的元素，key分布不均匀时某个分段满了，总的元素个数会少于capacity。
+// 每个分段至少要能放1个元素，shardNum大于capacity时分段个数减少为capacity。
+// opts会应用到每个分段上，其中WithMaxCost设置的开销上限也按同样的方式拆分，每个分段至少为1。
+// 分段缓存不支持WithSnapshot（多个分段会写同一个文件），该配置会被忽略。
+func NewShardedCache(capacity, shardNum int, opts ...Option) *ShardedCache {
+	if shardNum <= 0 {
+		shardNum = 1
+	}
+	if capacity > 0 && shardNum > capacity {
+		shardNum = capacity
+	}
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.snapshotPath != "" {
+		opts = append(opts[:len(opts):len(opts)], WithSnapshot("", 0))
+	}
+	shards := make([]*Cache, shardNum)
+	for i := range shards {
+		shardOpts := opts
+		if o.maxCost > 0 {
+			shardCost := max(splitShare(o.maxCost, shardNum, i), 1)
+			shardOpts = append(opts[:len(opts):len(opts)], WithMaxCost(shardCost))
+		}
+		shards[i] = NewCache(int(splitShare(int64(capacity), shardNum, i)), shardOpts...)
+	}
+	return &ShardedCache{shards: shards}
+}
+
+// 把total拆成n份，第i份的大小：前total%n份比其它的多1，<=0时每份都是0
+func splitShare(total int64, n, i int) int64 {
+	if total <= 0 {
+		return 0
+	}
+	share := total / int64(n)
+	if int64(i) < total%int64(n) {
+		share++
+	}
+	return share
+}
+
+// 把元素放入key所在的分段中，返回该分段被淘汰的元素（没有淘汰则返回nil）
+func (sc *ShardedCache) Put(key string, val interface{}) interface{} {
+	return sc.shard(key).Put(key, val)
+}
+
+// 把元素放入key所在的分段中，并单独指定过期时间
+func (sc *ShardedCache) PutWithTTL(key string, val interface{}, ttl time.Duration) interface{} {
+	return sc.shard(key).PutWithTTL(key, val, ttl)
+}
+
+// 把元素放入key所在的分段中，并直接指定开销
+func (sc *ShardedCache) PutWithCost(key string, val interface{}, cost int64) interface{} {
+	return sc.shard(key).PutWithCost(key, val, cost)
+}
+
+// 从key所在的分段中获取元素
+func (sc *ShardedCache) Get(key string) interface{} {
+	return sc.shard(key).Get(key)
+}
+
+// 从key所在的分段中获取元素，并返回元素是否存在
+func (sc *ShardedCache) GetOK(key string) (interface{}, bool) {
+	return sc.shard(key).GetOK(key)
+}
+
+// 获取元素但不影响淘汰顺序
+func (sc *ShardedCache) Peek(key string) (interface{}, bool) {
+	return sc.shard(key).Peek(key)
+}
+
+// 判断元素是否存在，不影响淘汰顺序
+func (sc *ShardedCache) Contains(key string) bool {
+	return sc.shard(key).Contains(key)
+}
+
+// 所有分段的元素个数之和
+func (sc *ShardedCache) Len() int {
+	n := 0
+	for _, shard := range sc.shards {
+		n += shard.Len()
+	}
+	return n
+}
+
+// 汇总所有分段的统计信息
+func (sc *ShardedCache) Stats() Stats {
+	var stats Stats
+	for _, shard := range sc.shards {
+		stats = stats.add(shard.Stats())
+	}
+	return stats
+}
+
+// 所有分段的开销之和
+func (sc *ShardedCache) TotalCost() int64 {
+	var cost int64
+	for _, shard := range sc.shards {
+		cost += shard.TotalCost()
+	}
+	return cost
+}
+
+// 返回所有分段的key。每个分段内部按最近使用顺序排列，分段之间没有全局顺序
+func (sc *ShardedCache) Keys() []string {
+	var keys []string
+	for _, shard := range sc.shards {
+		keys = append(keys, shard.Keys()...)
+	}
+	return keys
+}
+
+// 修改总容量，和NewShardedCache一样拆分到每个分段上，capacity<=0表示不限制元素个数，返回被淘汰的元素个数。
+// 分段个数不会改变，capacity小于分段个数时多出来的分段不再放任何元素
+func (sc *ShardedCache) Resize(capacity int) int {
+	evicted := 0
+	for i, shard := range sc.shards {
+		shardCap := int(splitShare(int64(capacity), len(sc.shards), i))
+		evicted += shard.lru.resize(shardCap, capacity > 0 && shardCap == 0)
+	}
+	return evicted
+}
+
+// 从key所在的分段中删除元素
+func (sc *ShardedCache) Delete(key string) bool {
+	return sc.shard(key).Delete(key)
+}
+
+// 清空所有分段
+func (sc *ShardedCache) Purge() {
+	for _, shard := range sc.shards {
+		shard.Purge()
+	}
+}
+
+// 给所有分段设置元素被移除时的回调函数
+func (sc *ShardedCache) OnEvict(fn func(key string, value interface{}, reason EvictReason)) {
+	for _, shard := range sc.shards {
+		shard.OnEvict(fn)
+	}
+}
+
+// 停止所有分段的后台清理goroutine，返回第一个关闭错误
+func (sc *ShardedCache) Close() error {
+	var err error
+	for _, shard := range sc.shards {
+		if closeErr := shard.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// 分段个数
+func (sc *ShardedCache) ShardNum() int {
+	return len(sc.shards)
+}
+
+// 用fnv-1a哈希算法计算key所在的分段
+func (sc *ShardedCache) shard(key string) *Cache {
+	if len(sc.shards) == 1 {
+		return sc.shards[0]
+	}
+	return sc.shards[fnv32a(key)%uint32(len(sc.shards))]
+}
+
+// fnv-1a哈希，直接遍历字符串，避免hash/fnv把key转成[]byte时的内存分配
+func fnv32a(key string) uint32 {
+	const (
+		offset32 = 2166136261
+		prime32  = 16777619
+	)
+	h := uint32(offset32)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= prime32
+	}
+	return h
+}
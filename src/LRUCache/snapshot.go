@@ -0,0 +1,181 @@
+package cache
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// 缓存持久化：把缓存中的元素按从最近使用到最久未使用的顺序序列化，
+// 重启后恢复出来的缓存和保存时的淘汰顺序完全相同，不用从冷缓存开始。
+
+// 编码方式，可以自己实现，比如用msgpack或者protobuf
+type Codec interface {
+	NewEncoder(w io.Writer) Encoder
+	NewDecoder(r io.Reader) Decoder
+}
+
+type Encoder interface {
+	Encode(v interface{}) error
+}
+
+type Decoder interface {
+	Decode(v interface{}) error
+}
+
+var (
+	// gob编码。Cache的value是interface{}，其中的自定义类型需要先调用gob.Register注册
+	GobCodec Codec = gobCodec{}
+	// JSON编码。Cache的value是interface{}，恢复出来的值是JSON解码后的类型（map[string]interface{}、float64等）
+	JSONCodec Codec = jsonCodec{}
+)
+
+type gobCodec struct{}
+
+func (gobCodec) NewEncoder(w io.Writer) Encoder { return gob.NewEncoder(w) }
+func (gobCodec) NewDecoder(r io.Reader) Decoder { return gob.NewDecoder(r) }
+
+type jsonCodec struct{}
+
+func (jsonCodec) NewEncoder(w io.Writer) Encoder { return json.NewEncoder(w) }
+func (jsonCodec) NewDecoder(r io.Reader) Decoder { return json.NewDecoder(r) }
+
+// 快照格式的版本号，格式变化时递增
+const snapshotVersion = 1
+
+// 快照的头部，后面紧跟Count个snapshotEntry
+type snapshotHeader struct {
+	Version int
+	Count   int
+}
+
+type snapshotEntry[K comparable, V any] struct {
+	Key      K
+	Value    V
+	ExpireAt time.Time
+	Cost     int64
+}
+
+// 把缓存中所有未过期的元素按从最近使用到最久未使用的顺序写入w
+func (lru *LRU[K, V]) SaveTo(w io.Writer) error {
+	lru.lock.RLock()
+	now := lru.now()
+	entries := make([]snapshotEntry[K, V], 0, len(lru.cache))
+	for e := lru.head; e != nil; e = e.next {
+		if !e.expired(now) {
+			entries = append(entries, snapshotEntry[K, V]{Key: e.Key, Value: e.Value, ExpireAt: e.expireAt, Cost: e.cost})
+		}
+	}
+	lru.lock.RUnlock()
+
+	encoder := lru.codec.NewEncoder(w)
+	if err := encoder.Encode(snapshotHeader{Version: snapshotVersion, Count: len(entries)}); err != nil {
+		return err
+	}
+	for i := range entries {
+		if err := encoder.Encode(&entries[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 从r中读取SaveTo写入的元素放入缓存，读取完成后元素的先后顺序和保存时相同，已经过期的元素会被跳过。
+// 缓存中已有的元素会排在恢复出来的元素后面，容量不够时按正常规则淘汰。
+func (lru *LRU[K, V]) LoadFrom(r io.Reader) error {
+	decoder := lru.codec.NewDecoder(r)
+	var header snapshotHeader
+	if err := decoder.Decode(&header); err != nil {
+		return err
+	}
+	if header.Version != snapshotVersion {
+		return fmt.Errorf("cache: unsupported snapshot version %d", header.Version)
+	}
+	if header.Count < 0 {
+		return fmt.Errorf("cache: invalid snapshot entry count %d", header.Count)
+	}
+	// Count来自文件内容，不能直接按它分配内存
+	var entries []snapshotEntry[K, V]
+	for i := 0; i < header.Count; i++ {
+		var e snapshotEntry[K, V]
+		if err := decoder.Decode(&e); err != nil {
+			return err
+		}
+		entries = append(entries, e)
+	}
+
+	// 快照中第一个是最近使用的元素，所以倒着放入
+	now := lru.now()
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		if !e.ExpireAt.IsZero() && now.After(e.ExpireAt) {
+			continue
+		}
+		lru.putAt(e.Key, e.Value, e.ExpireAt, e.Cost)
+	}
+	return nil
+}
+
+// 把快照保存到path文件中：先写入同目录下的临时文件，再重命名覆盖path
+func (lru *LRU[K, V]) SaveFile(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// 重命名成功后临时文件已经不存在了，这里只是清理失败时残留的临时文件
+		_ = os.Remove(tmp.Name())
+	}()
+
+	if err := lru.SaveTo(tmp); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// 从path文件中恢复快照，文件不存在时什么也不做
+func (lru *LRU[K, V]) LoadFile(path string) error {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return lru.LoadFrom(f)
+}
+
+// 定期快照goroutine，直到Close被调用
+func (lru *LRU[K, V]) snapshotLoop(interval time.Duration) {
+	if interval <= 0 {
+		// 只在Close时保存快照
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := lru.SaveFile(lru.snapshotPath); err != nil {
+				log.Println("cache: save snapshot failed!", err)
+			}
+		case <-lru.stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package cache
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	for _, codec := range []Codec{GobCodec, JSONCodec} {
+		src := NewCache(10, WithCodec(codec))
+		src.Put("1", "one")
+		src.Put("2", "two")
+		src.PutWithTTL("3", "three", time.Hour)
+		src.PutWithTTL("expired", "x", time.Millisecond)
+		src.Get("1")
+		time.Sleep(5 * time.Millisecond)
+
+		var buf bytes.Buffer
+		if err := src.SaveTo(&buf); err != nil {
+			t.Fatalf("%T SaveTo: %v", codec, err)
+		}
+		dst := NewCache(10, WithCodec(codec))
+		if err := dst.LoadFrom(&buf); err != nil {
+			t.Fatalf("%T LoadFrom: %v", codec, err)
+		}
+		if got, want := fmt.Sprint(dst.Keys()), "[1 3 2]"; got != want {
+			t.Fatalf("%T restored Keys() = %s, want %s", codec, got, want)
+		}
+		if v, _ := dst.Peek("3"); v != "three" {
+			t.Fatalf("%T restored value = %v", codec, v)
+		}
+		if ttl, _ := dst.TTL("3"); ttl <= 0 || ttl > time.Hour {
+			t.Fatalf("%T restored TTL = %v", codec, ttl)
+		}
+	}
+}
+
+func TestGenericSnapshot(t *testing.T) {
+	type point struct{ X, Y int }
+	src := NewLRU[int, point](3)
+	src.Put(1, point{1, 1})
+	src.Put(2, point{2, 2})
+
+	var buf bytes.Buffer
+	if err := src.SaveTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	dst := NewLRU[int, point](3, WithCodec(GobCodec))
+	if err := dst.LoadFrom(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if p, ok := dst.Get(2); !ok || p != (point{2, 2}) {
+		t.Fatalf("Get(2) = %v, %v", p, ok)
+	}
+}
+
+func TestSnapshotFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.snapshot")
+	cache := NewCache(10, WithSnapshot(path, 10*time.Millisecond))
+	cache.Put("a", 1)
+	time.Sleep(30 * time.Millisecond)
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("periodic snapshot not written: %v", err)
+	}
+	cache.Put("b", 2)
+	if err := cache.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := NewCache(10)
+	if err := restored.LoadFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(restored.Keys()); got != "[b a]" {
+		t.Fatalf("restored Keys() = %s, want [b a]", got)
+	}
+	if err := restored.LoadFile(filepath.Join(t.TempDir(), "missing")); err != nil {
+		t.Fatalf("LoadFile(missing) = %v", err)
+	}
+	matches, _ := filepath.Glob(path + ".tmp*")
+	if len(matches) != 0 {
+		t.Fatalf("temporary files left behind: %v", matches)
+	}
+}
+
+// 快照头中的元素个数来自文件内容，负数要报错，很大的数不能直接按它分配内存
+func TestLoadFromBadCount(t *testing.T) {
+	for _, data := range []string{`{"Version":1,"Count":-1}`, `{"Version":1,"Count":1000000000000}`} {
+		cache := NewCache(10, WithCodec(JSONCodec))
+		if err := cache.LoadFrom(bytes.NewBufferString(data)); err == nil {
+			t.Fatalf("LoadFrom(%s) succeeded", data)
+		}
+	}
+}
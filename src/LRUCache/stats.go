@@ -0,0 +1,118 @@
+package cache
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// 缓存统计信息的快照
+type Stats struct {
+	Hits        uint64 // Get命中次数，包括从磁盘层读回的元素
+	Misses      uint64 // Get未命中次数，包括命中了已过期的元素
+	Evictions   uint64 // 因为容量或者开销超过上限被淘汰的元素个数
+	Expirations uint64 // 因为过期被删除的元素个数
+	Size        int    // 当前元素个数
+	Cost        int64  // 当前所有元素的开销之和
+}
+
+// 命中率，没有任何Get时为0
+func (s Stats) HitRatio() float64 {
+	total := s.Hits + s.Misses
+	if total == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(total)
+}
+
+func (s Stats) add(o Stats) Stats {
+	return Stats{
+		Hits:        s.Hits + o.Hits,
+		Misses:      s.Misses + o.Misses,
+		Evictions:   s.Evictions + o.Evictions,
+		Expirations: s.Expirations + o.Expirations,
+		Size:        s.Size + o.Size,
+		Cost:        s.Cost + o.Cost,
+	}
+}
+
+// 能提供统计信息的缓存，*Cache、*LRU和*ShardedCache都实现了这个接口
+type StatsProvider interface {
+	Stats() Stats
+}
+
+// 以Prometheus文本格式导出缓存统计信息的http.Handler，每个缓存用cache标签区分
+type MetricsHandler struct {
+	lock   sync.RWMutex
+	caches map[string]StatsProvider
+}
+
+func NewMetricsHandler() *MetricsHandler {
+	return &MetricsHandler{caches: make(map[string]StatsProvider)}
+}
+
+// 注册需要导出统计信息的缓存，name作为cache标签的值，同名的缓存会被替换
+func (h *MetricsHandler) Register(name string, c StatsProvider) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.caches[name] = c
+}
+
+// 取消注册
+func (h *MetricsHandler) Unregister(name string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	delete(h.caches, name)
+}
+
+// 导出的指标：名字、类型、说明、取值方法
+var metrics = []struct {
+	name  string
+	kind  string
+	help  string
+	value func(s Stats) string
+}{
+	{"cache_hits_total", "counter", "Number of cache hits.", func(s Stats) string { return fmt.Sprint(s.Hits) }},
+	{"cache_misses_total", "counter", "Number of cache misses.", func(s Stats) string { return fmt.Sprint(s.Misses) }},
+	{"cache_evictions_total", "counter", "Number of entries evicted because the cache was full.", func(s Stats) string { return fmt.Sprint(s.Evictions) }},
+	{"cache_expirations_total", "counter", "Number of entries removed because they expired.", func(s Stats) string { return fmt.Sprint(s.Expirations) }},
+	{"cache_size", "gauge", "Current number of entries.", func(s Stats) string { return fmt.Sprint(s.Size) }},
+	{"cache_cost", "gauge", "Current total cost of entries.", func(s Stats) string { return fmt.Sprint(s.Cost) }},
+	{"cache_hit_ratio", "gauge", "Ratio of hits to total lookups.", func(s Stats) string { return fmt.Sprint(s.HitRatio()) }},
+}
+
+func (h *MetricsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	h.WriteMetrics(w)
+}
+
+// 以Prometheus文本格式把所有已注册缓存的统计信息写入w，缓存按名字排序
+func (h *MetricsHandler) WriteMetrics(w io.Writer) {
+	h.lock.RLock()
+	names := make([]string, 0, len(h.caches))
+	stats := make(map[string]Stats, len(h.caches))
+	for name, c := range h.caches {
+		names = append(names, name)
+		stats[name] = c.Stats()
+	}
+	h.lock.RUnlock()
+	sort.Strings(names)
+
+	for _, m := range metrics {
+		fmt.Fprintf(w, "# HELP %s %s\n", m.name, m.help)
+		fmt.Fprintf(w, "# TYPE %s %s\n", m.name, m.kind)
+		for _, name := range names {
+			fmt.Fprintf(w, "%s{cache=\"%s\"} %s\n", m.name, escapeLabelValue(name), m.value(stats[name]))
+		}
+	}
+}
+
+// 按Prometheus文本格式的要求转义标签值中的反斜杠、双引号和换行
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(v string) string {
+	return labelValueEscaper.Replace(v)
+}
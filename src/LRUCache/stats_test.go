@@ -0,0 +1,77 @@
+package cache
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	cache := NewCache(2)
+	cache.Put("1", "one")
+	cache.Put("2", "two")
+	cache.Put("3", "three")
+	cache.PutWithTTL("4", "four", time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	cache.Get("3")
+	cache.Get("4")
+	cache.Get("missing")
+
+	stats := cache.Stats()
+	want := Stats{Hits: 1, Misses: 2, Evictions: 2, Expirations: 1, Size: 1, Cost: 1}
+	if stats != want {
+		t.Fatalf("Stats() = %+v, want %+v", stats, want)
+	}
+	if ratio := stats.HitRatio(); ratio < 0.33 || ratio > 0.34 {
+		t.Fatalf("HitRatio() = %f, want 1/3", ratio)
+	}
+}
+
+func TestShardedStats(t *testing.T) {
+	cache := NewShardedCache(100, 4)
+	for i := 0; i < 10; i++ {
+		cache.Put(string(rune('a'+i)), i)
+		cache.Get(string(rune('a' + i)))
+	}
+	if stats := cache.Stats(); stats.Hits != 10 || stats.Size != 10 {
+		t.Fatalf("Stats() = %+v", stats)
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	sessions := NewCache(10)
+	sessions.Put("a", 1)
+	sessions.Get("a")
+	sessions.Get("b")
+
+	handler := NewMetricsHandler()
+	handler.Register(`sessions"1`, sessions)
+	handler.Register("empty", NewLRU[int, int](1))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	for _, line := range []string{
+		"# TYPE cache_hits_total counter",
+		`cache_hits_total{cache="sessions\"1"} 1`,
+		`cache_misses_total{cache="sessions\"1"} 1`,
+		`cache_size{cache="sessions\"1"} 1`,
+		`cache_hit_ratio{cache="sessions\"1"} 0.5`,
+		`cache_hit_ratio{cache="empty"} 0`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("metrics missing %q:\n%s", line, body)
+		}
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+
+	handler.Unregister("empty")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if strings.Contains(rec.Body.String(), `cache="empty"`) {
+		t.Error("unregistered cache still exported")
+	}
+}
@@ -0,0 +1,218 @@
+package cache
+
+import "sync"
+
+// W-TinyLFU淘汰策略（Caffeine使用的算法）：
+// 新元素先进入一个很小的窗口LRU（约占1%的容量），从窗口淘汰出来的元素作为候选者，
+// 和主缓存probation段的淘汰者比较估算出的访问频率，频率更高的那个才能留在主缓存中。
+// 主缓存是分段LRU：probation段放只在主缓存中命中过0次的元素，再次命中后提升到protected段。
+// 访问频率用count-min sketch估算，定期把所有计数减半，让旧的访问频率逐渐失效。
+
+const (
+	tinyLFUWindowRatio    = 0.01 // 窗口LRU占总容量的比例
+	tinyLFUProtectedRatio = 0.80 // protected段占主缓存的比例
+)
+
+type tinyLFUCache struct {
+	lock         sync.Mutex
+	windowCap    int
+	mainCap      int
+	protectedCap int
+
+	window    *LRU[string, interface{}]
+	probation *LRU[string, interface{}]
+	protected *LRU[string, interface{}]
+	sketch    *countMinSketch
+}
+
+func newTinyLFU(capacity int) *tinyLFUCache {
+	windowCap := max(1, int(float64(capacity)*tinyLFUWindowRatio))
+	mainCap := capacity - windowCap
+	return &tinyLFUCache{
+		windowCap:    windowCap,
+		mainCap:      mainCap,
+		protectedCap: int(float64(mainCap) * tinyLFUProtectedRatio),
+		window:       NewLRU[string, interface{}](0),
+		probation:    NewLRU[string, interface{}](0),
+		protected:    NewLRU[string, interface{}](0),
+		sketch:       newCountMinSketch(capacity),
+	}
+}
+
+func (c *tinyLFUCache) Put(key string, val interface{}) interface{} {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.sketch.increment(key)
+	if c.window.Contains(key) {
+		c.window.Put(key, val)
+		return nil
+	}
+	if c.protected.Contains(key) {
+		c.protected.Put(key, val)
+		return nil
+	}
+	if c.probation.Contains(key) {
+		c.promote(key, val)
+		return nil
+	}
+
+	c.window.Put(key, val)
+	if c.window.Len() <= c.windowCap {
+		return nil
+	}
+	// 窗口满了，淘汰出来的元素作为候选者尝试进入主缓存
+	candidateKey, candidateVal, _ := c.window.RemoveOldest()
+	if c.probation.Len()+c.protected.Len() < c.mainCap {
+		c.probation.Put(candidateKey, candidateVal)
+		return nil
+	}
+	victims := c.probation
+	if victims.Len() == 0 {
+		victims = c.protected
+	}
+	victimKey, _, ok := victims.Oldest()
+	if !ok {
+		// 主缓存容量为0，候选者直接被淘汰
+		return candidateVal
+	}
+	if c.sketch.estimate(candidateKey) <= c.sketch.estimate(victimKey) {
+		return candidateVal
+	}
+	_, victimVal, _ := victims.RemoveOldest()
+	c.probation.Put(candidateKey, candidateVal)
+	return victimVal
+}
+
+func (c *tinyLFUCache) Get(key string) interface{} {
+	val, _ := c.GetOK(key)
+	return val
+}
+
+func (c *tinyLFUCache) GetOK(key string) (interface{}, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.sketch.increment(key)
+	if val, ok := c.window.Get(key); ok {
+		return val, true
+	}
+	if val, ok := c.protected.Get(key); ok {
+		return val, true
+	}
+	if val, ok := c.probation.Peek(key); ok {
+		c.promote(key, val)
+		return val, true
+	}
+	return nil, false
+}
+
+func (c *tinyLFUCache) Contains(key string) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.window.Contains(key) || c.probation.Contains(key) || c.protected.Contains(key)
+}
+
+func (c *tinyLFUCache) Delete(key string) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.window.Delete(key) || c.probation.Delete(key) || c.protected.Delete(key)
+}
+
+func (c *tinyLFUCache) Len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.window.Len() + c.probation.Len() + c.protected.Len()
+}
+
+func (c *tinyLFUCache) Purge() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.window.Purge()
+	c.probation.Purge()
+	c.protected.Purge()
+	c.sketch.reset()
+}
+
+// 把probation段中再次命中的元素提升到protected段，protected段超出配额时把它最久未使用的元素降级回probation段
+func (c *tinyLFUCache) promote(key string, val interface{}) {
+	c.probation.Delete(key)
+	c.protected.Put(key, val)
+	if c.protected.Len() > c.protectedCap {
+		if demotedKey, demotedVal, ok := c.protected.RemoveOldest(); ok {
+			c.probation.Put(demotedKey, demotedVal)
+		}
+	}
+}
+
+// count-min sketch：用4行计数器估算key的访问频率，取各行中最小的计数作为估计值。
+// 计数器最大为15，累计增加sampleSize次之后所有计数减半。
+type countMinSketch struct {
+	rows       [4][]uint8
+	mask       uint32
+	additions  int
+	sampleSize int
+}
+
+func newCountMinSketch(capacity int) *countMinSketch {
+	width := 16
+	for width < capacity {
+		width <<= 1
+	}
+	s := &countMinSketch{mask: uint32(width - 1), sampleSize: 10 * max(capacity, 1)}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, width)
+	}
+	return s
+}
+
+func (s *countMinSketch) increment(key string) {
+	h1, h2 := sketchHashes(key)
+	for i := range s.rows {
+		idx := (h1 + uint32(i)*h2) & s.mask
+		if s.rows[i][idx] < 15 {
+			s.rows[i][idx]++
+		}
+	}
+	s.additions++
+	if s.additions >= s.sampleSize {
+		s.halve()
+	}
+}
+
+func (s *countMinSketch) estimate(key string) uint8 {
+	h1, h2 := sketchHashes(key)
+	freq := uint8(15)
+	for i := range s.rows {
+		if v := s.rows[i][(h1+uint32(i)*h2)&s.mask]; v < freq {
+			freq = v
+		}
+	}
+	return freq
+}
+
+// 所有计数减半，让旧的访问频率逐渐失效
+func (s *countMinSketch) halve() {
+	for i := range s.rows {
+		for j := range s.rows[i] {
+			s.rows[i][j] >>= 1
+		}
+	}
+	s.additions /= 2
+}
+
+func (s *countMinSketch) reset() {
+	for i := range s.rows {
+		for j := range s.rows[i] {
+			s.rows[i][j] = 0
+		}
+	}
+	s.additions = 0
+}
+
+// 用双重哈希为每一行生成不同的下标：第i行的下标为h1+i*h2
+func sketchHashes(key string) (uint32, uint32) {
+	h := fnv32a(key)
+	h2 := h>>17 | h<<15
+	return h, h2 | 1
+}
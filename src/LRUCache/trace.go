@@ -0,0 +1,67 @@
+package cache
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// 基于访问轨迹的命中率测试：按顺序回放一组key，每个key先Get，未命中时再Put，
+// 统计各种淘汰策略在同一条访问轨迹下的命中率。
+
+type TraceResult struct {
+	Policy PolicyType
+	Hits   int
+	Misses int
+}
+
+// 命中率
+func (r TraceResult) HitRatio() float64 {
+	total := r.Hits + r.Misses
+	if total == 0 {
+		return 0
+	}
+	return float64(r.Hits) / float64(total)
+}
+
+// 在缓存p上回放访问轨迹trace，返回命中和未命中次数
+func ReplayTrace(p Policy, trace []string) TraceResult {
+	var result TraceResult
+	for _, key := range trace {
+		if _, ok := p.GetOK(key); ok {
+			result.Hits++
+			continue
+		}
+		result.Misses++
+		p.Put(key, key)
+	}
+	return result
+}
+
+// 对每种淘汰策略创建容量为capacity的缓存并回放同一条访问轨迹，policies为空时测试所有策略
+func CompareTrace(capacity int, trace []string, policies ...PolicyType) []TraceResult {
+	if len(policies) == 0 {
+		policies = Policies
+	}
+	results := make([]TraceResult, 0, len(policies))
+	for _, policy := range policies {
+		result := ReplayTrace(NewPolicy(policy, capacity), trace)
+		result.Policy = policy
+		results = append(results, result)
+	}
+	return results
+}
+
+// 读取访问轨迹，每行一个key，忽略空行和#开头的注释行
+func ReadTrace(r io.Reader) ([]string, error) {
+	var trace []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		trace = append(trace, line)
+	}
+	return trace, scanner.Err()
+}
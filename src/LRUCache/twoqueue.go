@@ -0,0 +1,132 @@
+package cache
+
+import "sync"
+
+// 2Q淘汰策略：新元素先放入recent队列（先进先出），被再次访问时才提升到frequent队列（LRU）。
+// 从recent队列淘汰的key会记在ghost队列中，ghost中的key再次放入时直接进入frequent队列。
+// 一次性扫描的数据只会经过recent队列，不会把frequent队列中的热点数据挤出去。
+
+const (
+	twoQueueRecentRatio = 0.25 // recent队列占总容量的比例
+	twoQueueGhostRatio  = 0.50 // ghost队列能记住的key个数占总容量的比例
+)
+
+type twoQueueCache struct {
+	lock       sync.Mutex
+	capacity   int
+	recentSize int
+	recent     *LRU[string, interface{}] // 只被访问过一次的元素，只用Peek访问，所以是FIFO
+	frequent   *LRU[string, interface{}] // 被访问过多次的元素
+	ghost      *LRU[string, struct{}]    // 最近从recent队列淘汰的key
+}
+
+func newTwoQueue(capacity int) *twoQueueCache {
+	recentSize := int(float64(capacity) * twoQueueRecentRatio)
+	ghostSize := int(float64(capacity) * twoQueueGhostRatio)
+	if ghostSize <= 0 {
+		ghostSize = 1
+	}
+	return &twoQueueCache{
+		capacity:   capacity,
+		recentSize: recentSize,
+		recent:     NewLRU[string, interface{}](0),
+		frequent:   NewLRU[string, interface{}](0),
+		ghost:      NewLRU[string, struct{}](ghostSize),
+	}
+}
+
+func (c *twoQueueCache) Put(key string, val interface{}) interface{} {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.frequent.Contains(key) {
+		c.frequent.Put(key, val)
+		return nil
+	}
+	// 在recent队列中再次出现，提升到frequent队列
+	if c.recent.Contains(key) {
+		c.recent.Delete(key)
+		c.frequent.Put(key, val)
+		return nil
+	}
+	// 最近被淘汰过的key，说明不是一次性数据，直接放入frequent队列
+	if c.ghost.Contains(key) {
+		removed := c.ensureSpace(true)
+		c.ghost.Delete(key)
+		c.frequent.Put(key, val)
+		return removed
+	}
+	removed := c.ensureSpace(false)
+	c.recent.Put(key, val)
+	return removed
+}
+
+func (c *twoQueueCache) Get(key string) interface{} {
+	val, _ := c.GetOK(key)
+	return val
+}
+
+func (c *twoQueueCache) GetOK(key string) (interface{}, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if val, ok := c.frequent.Get(key); ok {
+		return val, true
+	}
+	if val, ok := c.recent.Peek(key); ok {
+		c.recent.Delete(key)
+		c.frequent.Put(key, val)
+		return val, true
+	}
+	return nil, false
+}
+
+func (c *twoQueueCache) Contains(key string) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.frequent.Contains(key) || c.recent.Contains(key)
+}
+
+func (c *twoQueueCache) Delete(key string) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.frequent.Delete(key) || c.recent.Delete(key) {
+		return true
+	}
+	c.ghost.Delete(key)
+	return false
+}
+
+func (c *twoQueueCache) Len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.recent.Len() + c.frequent.Len()
+}
+
+func (c *twoQueueCache) Purge() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.recent.Purge()
+	c.frequent.Purge()
+	c.ghost.Purge()
+}
+
+// 缓存满了时腾出一个位置，返回被淘汰的元素。
+// recent队列超过它的配额时从recent淘汰（并记入ghost），否则从frequent淘汰。
+// recentEvict为true表示要放入的key来自ghost，此时recent队列刚好等于配额也从recent淘汰。
+func (c *twoQueueCache) ensureSpace(recentEvict bool) interface{} {
+	recentLen := c.recent.Len()
+	if recentLen+c.frequent.Len() < c.capacity {
+		return nil
+	}
+	if recentLen > 0 && (recentLen > c.recentSize || (recentLen == c.recentSize && !recentEvict)) {
+		key, val, _ := c.recent.RemoveOldest()
+		c.ghost.Put(key, struct{}{})
+		return val
+	}
+	if _, val, ok := c.frequent.RemoveOldest(); ok {
+		return val
+	}
+	_, val, _ := c.recent.RemoveOldest()
+	return val
+}